@@ -0,0 +1,107 @@
+// Package ahocorasick finds occurrences of many literals in a single pass over
+// the input with an Aho-Corasick automaton.
+package ahocorasick
+
+import "github.com/susji/mre/input"
+
+type state struct {
+	next map[rune]int
+	// fail is the state of the longest proper suffix of this state which
+	// is also in the trie.
+	fail int
+	// out is the length in bytes of the longest literal which ends in this
+	// state. It is zero if none does.
+	out int
+}
+
+// Matcher is an automaton built out of a set of literals. It is safe for
+// concurrent use.
+type Matcher struct {
+	states []state
+	// maxLen is the length of the longest literal in bytes.
+	maxLen int
+}
+
+// New builds a matcher for lits. The literals must not be empty.
+func New(lits []string) *Matcher {
+	m := &Matcher{states: []state{{next: map[rune]int{}}}}
+	for _, lit := range lits {
+		if len(lit) > m.maxLen {
+			m.maxLen = len(lit)
+		}
+		s := 0
+		for _, r := range lit {
+			next, ok := m.states[s].next[r]
+			if !ok {
+				next = len(m.states)
+				m.states = append(m.states, state{next: map[rune]int{}})
+				m.states[s].next[r] = next
+			}
+			s = next
+		}
+		m.states[s].out = len(lit)
+	}
+	// The failure links are found breadth-first, so the links of shorter
+	// states are ready when the longer ones need them.
+	queue := []int{}
+	for _, next := range m.states[0].next {
+		queue = append(queue, next)
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for r, next := range m.states[s].next {
+			f := m.states[s].fail
+			for {
+				if to, ok := m.states[f].next[r]; ok {
+					m.states[next].fail = to
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = m.states[f].fail
+			}
+			if out := m.states[m.states[next].fail].out; out > m.states[next].out {
+				m.states[next].out = out
+			}
+			queue = append(queue, next)
+		}
+	}
+	return m
+}
+
+func (m *Matcher) step(s int, r rune) int {
+	for {
+		if next, ok := m.states[s].next[r]; ok {
+			return next
+		}
+		if s == 0 {
+			return 0
+		}
+		s = m.states[s].fail
+	}
+}
+
+// Index returns the byte offset of the leftmost occurrence of any of the
+// literals at or after byte offset from, or -1 if there is none.
+func (m *Matcher) Index(in input.Input, from int) int {
+	best := -1
+	s := 0
+	for pos := from; ; {
+		// A literal beginning before best would have ended by now.
+		if best >= 0 && pos >= best+m.maxLen {
+			break
+		}
+		r, w := in.Step(pos)
+		if w == 0 {
+			break
+		}
+		s = m.step(s, r)
+		pos += w
+		if out := m.states[s].out; out > 0 && (best < 0 || pos-out < best) {
+			best = pos - out
+		}
+	}
+	return best
+}
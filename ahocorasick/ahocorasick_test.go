@@ -0,0 +1,65 @@
+package ahocorasick_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/susji/mre/ahocorasick"
+	"github.com/susji/mre/input"
+)
+
+// naive finds the leftmost occurrence of any literal the slow way.
+func naive(text string, lits []string, from int) int {
+	best := -1
+	for _, lit := range lits {
+		if i := strings.Index(text[from:], lit); i >= 0 {
+			if best < 0 || from+i < best {
+				best = from + i
+			}
+		}
+	}
+	return best
+}
+
+func TestIndex(t *testing.T) {
+	type entry struct {
+		lits  []string
+		texts []string
+	}
+
+	table := []entry{
+		{
+			lits:  []string{"error", "warning", "fatal", "panic"},
+			texts: []string{"", "ok", "a warning and an error", "fatal"},
+		},
+		{
+			// A literal which begins earlier may end later.
+			lits:  []string{"abcd", "bc"},
+			texts: []string{"abcd", "xabce", "bcabcd"},
+		},
+		{
+			lits:  []string{"he", "she", "his", "hers"},
+			texts: []string{"ushers", "ahishers", "shhe"},
+		},
+		{
+			lits:  []string{"äö", "ö"},
+			texts: []string{"aäöö", "\xffö", "ä"},
+		},
+	}
+
+	for _, te := range table {
+		m := ahocorasick.New(te.lits)
+		for _, text := range te.texts {
+			for from := 0; from <= len(text); from++ {
+				exp := naive(text, te.lits, from)
+				for _, in := range []input.Input{
+					input.String(text), input.Bytes(text)} {
+					if got := m.Index(in, from); got != exp {
+						t.Errorf("%q in %q from %d: wanted %d, got %d",
+							te.lits, text, from, exp, got)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Package ast describes the syntax tree of a parsed expression. The tree is
+// what the parser sees, and it is lowered into a matcher tree only after
+// parsing. This makes it suitable for writing linters and other analyzers.
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+const REPEAT_UNBOUND = -1
+
+// REPEAT_MAX is the largest count a repetition may have, like with Go's
+// regexp.
+const REPEAT_MAX = 1000
+
+// Pos tells which columns of the expression a node spans: from Column up to
+// but not including End. Columns are counted in runes and begin from one.
+type Pos struct {
+	Column, End uint
+}
+
+func (p Pos) Position() Pos {
+	return p
+}
+
+// Node is a node of the syntax tree.
+type Node interface {
+	Position() Pos
+}
+
+// Literal matches the rune Rune. If Fold is set, it also matches the runes
+// Rune folds to.
+type Literal struct {
+	Pos
+	Rune rune
+	Fold bool
+}
+
+// Range is an inclusive range of runes. A single rune has Lo == Hi.
+type Range struct {
+	Lo, Hi rune
+}
+
+// CharClass matches one rune which falls into one of its Ranges. If Negate
+// is set, it matches one rune which falls into none of them. The dot is a
+// negated class of '\n', or without any ranges if it matches a newline,
+// too.
+type CharClass struct {
+	Pos
+	Negate bool
+	Ranges []Range
+}
+
+// Class matches one rune of the shorthand class Name, such as 'd' for \d
+// and 'D' for \D.
+type Class struct {
+	Pos
+	Name rune
+}
+
+// Property matches one rune of the Unicode general category or script Name,
+// such as "Greek" for \p{Greek}. If Negate is set, it matches one rune
+// outside of it.
+type Property struct {
+	Pos
+	Name   string
+	Negate bool
+}
+
+// Concat matches its Subs one after another.
+type Concat struct {
+	Pos
+	Subs []Node
+}
+
+// Alternate matches one of its Subs. The earlier ones are preferred.
+type Alternate struct {
+	Pos
+	Subs []Node
+}
+
+// Repeat matches Sub from Min to Max times. If Max is REPEAT_UNBOUND, there
+// is no upper limit. If Lazy is set, fewer repetitions are preferred over
+// more. If Possessive is set, repetitions are never given back.
+type Repeat struct {
+	Pos
+	Sub        Node
+	Min, Max   int
+	Lazy       bool
+	Possessive bool
+}
+
+// Group is a parenthesized subexpression. Index is the number of its
+// capture, and Name is its name, if it was given one. A group which does not
+// capture, such as (?:..) or (?i:..), has an Index of zero.
+type Group struct {
+	Pos
+	Sub   Node
+	Index int
+	Name  string
+}
+
+// Atomic matches Sub only in the way it prefers. What it matched is never
+// given back.
+type Atomic struct {
+	Pos
+	Sub Node
+}
+
+type AnchorKind uint8
+
+const (
+	ANCHOR_BEGIN = AnchorKind(iota)
+	ANCHOR_END
+	ANCHOR_BEGIN_LINE
+	ANCHOR_END_LINE
+)
+
+// Anchor matches the beginning or the end of the input without consuming
+// anything. The line anchors also match right after and right before a
+// '\n'.
+type Anchor struct {
+	Pos
+	Kind AnchorKind
+}
+
+// Walk calls f for n and then, if f returns true, for each of its children
+// in order.
+func Walk(n Node, f func(Node) bool) {
+	if n == nil || !f(n) {
+		return
+	}
+	switch v := n.(type) {
+	case *Concat:
+		for _, s := range v.Subs {
+			Walk(s, f)
+		}
+	case *Alternate:
+		for _, s := range v.Subs {
+			Walk(s, f)
+		}
+	case *Repeat:
+		Walk(v.Sub, f)
+	case *Group:
+		Walk(v.Sub, f)
+	case *Atomic:
+		Walk(v.Sub, f)
+	}
+}
+
+func dump(n Node, b *strings.Builder, level int) {
+	w := func(s string) {
+		b.WriteString(strings.Repeat("-", level*2+1) + s + "\n")
+	}
+	rec := func(n Node) {
+		dump(n, b, level+1)
+	}
+	switch v := n.(type) {
+	case nil:
+		w("nil")
+	case *Literal:
+		if v.Fold {
+			w(fmt.Sprintf("'%c'/i", v.Rune))
+		} else {
+			w(fmt.Sprintf("'%c'", v.Rune))
+		}
+	case *CharClass:
+		s := &strings.Builder{}
+		s.WriteRune('[')
+		if v.Negate {
+			s.WriteRune('^')
+		}
+		for _, r := range v.Ranges {
+			if r.Lo == r.Hi {
+				fmt.Fprintf(s, "%q", r.Lo)
+			} else {
+				fmt.Fprintf(s, "%q-%q", r.Lo, r.Hi)
+			}
+		}
+		s.WriteRune(']')
+		w(s.String())
+	case *Class:
+		w(fmt.Sprintf("\\%c", v.Name))
+	case *Property:
+		if v.Negate {
+			w(fmt.Sprintf("\\P{%s}", v.Name))
+		} else {
+			w(fmt.Sprintf("\\p{%s}", v.Name))
+		}
+	case *Concat:
+		w("concat")
+		for _, s := range v.Subs {
+			rec(s)
+		}
+	case *Alternate:
+		w("alternate")
+		for _, s := range v.Subs {
+			rec(s)
+		}
+	case *Repeat:
+		if v.Lazy {
+			w(fmt.Sprintf("{%d,%d}?", v.Min, v.Max))
+		} else if v.Possessive {
+			w(fmt.Sprintf("{%d,%d}+", v.Min, v.Max))
+		} else {
+			w(fmt.Sprintf("{%d,%d}", v.Min, v.Max))
+		}
+		rec(v.Sub)
+	case *Group:
+		switch {
+		case v.Index == 0:
+			w("group")
+		case v.Name != "":
+			w(fmt.Sprintf("group#%d<%s>", v.Index, v.Name))
+		default:
+			w(fmt.Sprintf("group#%d", v.Index))
+		}
+		rec(v.Sub)
+	case *Atomic:
+		w("atomic")
+		rec(v.Sub)
+	case *Anchor:
+		switch v.Kind {
+		case ANCHOR_BEGIN:
+			w("^")
+		case ANCHOR_END:
+			w("$")
+		case ANCHOR_BEGIN_LINE:
+			w("^/m")
+		case ANCHOR_END_LINE:
+			w("$/m")
+		}
+	default:
+		panic(fmt.Sprintf("missing case for %T", n))
+	}
+}
+
+// Dump builds and returns a textual representation of a syntax tree.
+func Dump(n Node) string {
+	b := &strings.Builder{}
+	dump(n, b, 0)
+	return b.String()
+}
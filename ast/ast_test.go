@@ -0,0 +1,53 @@
+package ast_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/susji/mre/ast"
+)
+
+func TestWalk(t *testing.T) {
+	tree := &ast.Concat{Subs: []ast.Node{
+		&ast.Anchor{Kind: ast.ANCHOR_BEGIN},
+		&ast.Group{Index: 1, Sub: &ast.Alternate{Subs: []ast.Node{
+			&ast.Literal{Rune: 'a'},
+			&ast.Repeat{Sub: &ast.Literal{Rune: 'b'}, Min: 0, Max: 1}}}},
+		&ast.CharClass{Ranges: []ast.Range{{Lo: 'c', Hi: 'd'}}},
+	}}
+
+	var got []rune
+	ast.Walk(tree, func(n ast.Node) bool {
+		if l, ok := n.(*ast.Literal); ok {
+			got = append(got, l.Rune)
+		}
+		return true
+	})
+	if exp := []rune{'a', 'b'}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("wanted %q, got %q", exp, got)
+	}
+
+	// Not descending into the group leaves its literals out.
+	count := 0
+	ast.Walk(tree, func(n ast.Node) bool {
+		count++
+		_, isGroup := n.(*ast.Group)
+		return !isGroup
+	})
+	if count != 4 {
+		t.Errorf("wanted 4 nodes, got %d", count)
+	}
+
+	exp := `-concat
+---^
+---group#1
+-----alternate
+-------'a'
+-------{0,1}
+---------'b'
+---['c'-'d']
+`
+	if d := ast.Dump(tree); d != exp {
+		t.Errorf("wanted dump\n%s\ngot\n%s", exp, d)
+	}
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/susji/mre"
+	"github.com/susji/mre/compile"
 )
 
 func main() {
 	var sre = flag.String("re", "", "Regular expression to evaluate")
 	var dump = flag.Bool("d", false, "Dump expression matcher tree")
+	var engine = flag.String("e", "backtrack", "Matching engine: backtrack or pikevm")
+	var trace = flag.Bool("t", false, "Trace the parser to stderr")
 	flag.Parse()
 
 	if len(*sre) == 0 {
@@ -20,9 +24,27 @@ func main() {
 		os.Exit(1)
 	}
 
-	re, err := mre.Compile(*sre)
+	opts := &mre.CompileOptions{}
+	if *trace {
+		opts.Tracer = compile.NewWriterTracer(os.Stderr)
+	}
+	switch *engine {
+	case "backtrack":
+		opts.Engine = mre.ENGINE_BACKTRACK
+	case "pikevm":
+		opts.Engine = mre.ENGINE_PIKEVM
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown engine: %s\n", *engine)
+		os.Exit(1)
+	}
+
+	re, err := mre.CompileWith(*sre, opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to compile: %v", err)
+		fmt.Fprintf(os.Stderr, "Unable to compile: %v\n", err)
+		var serr *mre.SyntaxError
+		if errors.As(err, &serr) {
+			fmt.Fprint(os.Stderr, serr.Caret())
+		}
 		os.Exit(1)
 	}
 	if *dump {
@@ -37,10 +59,10 @@ func main() {
 			break
 		}
 		line = strings.TrimRight(line, "\n")
-		matched := re.Match(line)
+		captures := re.FindStringSubmatch(line)
 		fmt.Printf("``%s''", line)
-		if matched {
-			fmt.Printf(" -> matched: %#v\n", re.Captures())
+		if captures != nil {
+			fmt.Printf(" -> matched: %#v\n", captures)
 		} else {
 			fmt.Printf(" -> did not match.\n")
 		}
@@ -2,10 +2,11 @@ package compile
 
 import (
 	"errors"
-	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
 
+	"github.com/susji/mre/ast"
 	"github.com/susji/mre/match"
 	"github.com/susji/mre/token"
 )
@@ -15,28 +16,282 @@ var bailPipe = errors.New("bailing for or-expr and '|'")
 var bailDollar = errors.New("bailing for strict end at '$'")
 
 type ctx struct {
-	pardepth   int
+	// parens holds the currently open '(' tokens.
+	parens     []*token.Token
 	ncapturers int
+	// names has the names given to captures so far.
+	names  map[string]bool
+	tracer Tracer
+	// flags are set with groups such as (?i) until the end of the
+	// enclosing group.
+	flags flagSet
 }
 
-func (ctx *ctx) set(toks *token.Tokens) (match.Node, error) {
+// flagSet has the flags which change how the atoms that follow them match.
+type flagSet struct {
+	// fold makes runes match case-insensitively.
+	fold bool
+	// multiline makes ^ and $ match at the beginning and end of lines.
+	multiline bool
+	// dotall makes '.' match '\n', too.
+	dotall bool
+	// ungreedy makes repetitions prefer fewer iterations over more.
+	ungreedy bool
+}
+
+// Options tune how an expression is compiled.
+type Options struct {
+	// Tracer, if not nil, follows the parser.
+	Tracer Tracer
+	// CaseInsensitive makes the whole expression match as if it began
+	// with (?i).
+	CaseInsensitive bool
+}
+
+// astRanges turns inclusive range pairs into syntax tree ranges.
+func astRanges(ranges []rune) []ast.Range {
+	ret := make([]ast.Range, 0, len(ranges)/2)
+	for i := 0; i < len(ranges); i += 2 {
+		ret = append(ret, ast.Range{Lo: ranges[i], Hi: ranges[i+1]})
+	}
+	return ret
+}
+
+// classRanges returns the ranges of a class out of the ranges it has when
+// not negated. When folding, the class is folded before it is negated, so
+// that \W does not match what \w folds to.
+func (ctx *ctx) classRanges(ranges []rune, negate bool) []rune {
+	if ctx.flags.fold {
+		ranges = match.FoldRanges(ranges)
+	}
+	if negate {
+		ranges = match.Complement(ranges)
+	}
+	return ranges
+}
+
+// flagGroup parses a group such as (?i-s), which sets or clears flags for
+// the rest of the enclosing group, or such as (?i:..), which sets them only
+// for what it encloses. Neither captures anything, and (?:..) only groups.
+// The atomic group (?>..) and named groups are parsed here, too. The current
+// token is the '('.
+func (ctx *ctx) flagGroup(toks *token.Tokens) (ast.Node, error) {
+	lparen := toks.Get()
+	toks.Get()
+	if tok := toks.Cur(); isRune(tok, 'P') || isRune(tok, '<') {
+		name, err := ctx.groupName(toks, lparen)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.group(toks, lparen, name)
+	}
+	if isRune(toks.Cur(), '>') {
+		toks.Get()
+		ctx.parens = append(ctx.parens, lparen)
+		flags := ctx.flags
+		sub, err := ctx.orexpr(toks)
+		ctx.flags = flags
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Atomic{Pos: from(toks, lparen), Sub: sub}, nil
+	}
+	flags, n, clear := ctx.flags, 0, false
+	for tok := toks.Cur(); ; tok = toks.Cur() {
+		if tok == nil {
+			return nil, errorAt(ERR_INVALID_FLAGS, toks, lparen, nil)
+		}
+		scoped := isRune(tok, ':')
+		end := tok.Kind() == token.TOK_RPAREN || scoped
+		if end && (n > 0 || scoped && !clear) {
+			toks.Get()
+			ctx.event("flags", "%+v", flags)
+			if tok.Kind() == token.TOK_RPAREN {
+				ctx.flags = flags
+				return nil, nil
+			}
+			ctx.parens = append(ctx.parens, lparen)
+			saved := ctx.flags
+			ctx.flags = flags
+			sub, err := ctx.orexpr(toks)
+			ctx.flags = saved
+			if err != nil {
+				return nil, err
+			}
+			return &ast.Group{Pos: from(toks, lparen), Sub: sub}, nil
+		}
+		var flag *bool
+		switch {
+		case isRune(tok, 'i'):
+			flag = &flags.fold
+		case isRune(tok, 'm'):
+			flag = &flags.multiline
+		case isRune(tok, 's'):
+			flag = &flags.dotall
+		case isRune(tok, 'U'):
+			flag = &flags.ungreedy
+		case tok.Kind() == token.TOK_DASH && !clear:
+			// At least one flag must follow the '-'.
+			clear, n = true, 0
+			toks.Get()
+			continue
+		default:
+			return nil, errorAt(ERR_INVALID_FLAGS, toks, lparen, tok)
+		}
+		*flag = !clear
+		toks.Get()
+		n++
+	}
+}
+
+// groupName parses the name of a group such as (?P<name>..) or (?<name>..).
+// The current token is the one after the '?'. A name is a non-empty sequence
+// of letters, digits and underscores, and it may be given only once.
+func (ctx *ctx) groupName(toks *token.Tokens, lparen *token.Token) (string, error) {
+	if isRune(toks.Cur(), 'P') {
+		toks.Get()
+	}
+	if tok := toks.Cur(); !isRune(tok, '<') {
+		return "", errorAt(ERR_INVALID_GROUP_NAME, toks, lparen, tok)
+	}
+	toks.Get()
+	name := &strings.Builder{}
+	for tok := toks.Cur(); ; tok = toks.Cur() {
+		if tok == nil {
+			return "", errorAt(ERR_INVALID_GROUP_NAME, toks, lparen, nil)
+		}
+		toks.Get()
+		if isRune(tok, '>') && name.Len() > 0 {
+			break
+		}
+		r := tok.Rune()
+		if tok.Kind() != token.TOK_RUNE && tok.Kind() != token.TOK_DIGIT ||
+			tok.Width() != 1 ||
+			!unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return "", errorAt(ERR_INVALID_GROUP_NAME, toks, lparen, tok)
+		}
+		name.WriteRune(r)
+	}
+	if ctx.names[name.String()] {
+		return "", errorAt(
+			ERR_DUPLICATE_GROUP_NAME, toks, lparen, toks.Prev())
+	}
+	ctx.names[name.String()] = true
+	return name.String(), nil
+}
+
+// group parses a capturing group after its '(', or after its name if it
+// has one.
+func (ctx *ctx) group(toks *token.Tokens, lparen *token.Token, name string) (ast.Node, error) {
+	ctx.event("atom", "'(' -> pardepth=%d", len(ctx.parens))
+	ctx.parens = append(ctx.parens, lparen)
+	index := ctx.ncapturers
+	ctx.ncapturers++
+	// Flags set within the group end with it.
+	flags := ctx.flags
+	sub, err := ctx.orexpr(toks)
+	ctx.flags = flags
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Group{
+		Pos: from(toks, lparen), Sub: sub, Index: index, Name: name}, nil
+}
+
+// span returns the position covering nodes. If there are none, the position
+// is an empty one at column at.
+func span(nodes []ast.Node, at uint) ast.Pos {
+	if len(nodes) == 0 {
+		return ast.Pos{Column: at, End: at}
+	}
+	return ast.Pos{
+		Column: nodes[0].Position().Column,
+		End:    nodes[len(nodes)-1].Position().End,
+	}
+}
+
+// from returns the position from the beginning of first up to the end of the
+// last token taken.
+func from(toks *token.Tokens, first *token.Token) ast.Pos {
+	last := toks.Prev()
+	return ast.Pos{Column: first.Column(), End: last.Column() + last.Width()}
+}
+
+// column returns the column of the next token.
+func column(toks *token.Tokens) uint {
+	if toks.Count() == 0 {
+		return toks.End()
+	}
+	return toks.Cur().Column()
+}
+
+// isRune tells if tok is the literal rune r.
+func isRune(tok *token.Token, r rune) bool {
+	return tok != nil && tok.Kind() == token.TOK_RUNE && tok.Rune() == r
+}
+
+// posix parses a POSIX class such as [:alpha:] or [:^alpha:] within a set
+// and returns its ranges. The class begins from the current token, and the
+// closing ']' is left as the current token.
+func (ctx *ctx) posix(toks *token.Tokens) ([]rune, error) {
+	first := toks.Get()
+	toks.Get()
+	negate := false
+	if tok := toks.Cur(); tok != nil && tok.Kind() == token.TOK_CARET {
+		negate = true
+		toks.Get()
+	}
+	name := &strings.Builder{}
+	for tok := toks.Cur(); tok != nil && tok.Kind() == token.TOK_RUNE &&
+		tok.Rune() != ':'; tok = toks.Cur() {
+		name.WriteRune(toks.Get().Rune())
+	}
+	if !isRune(toks.Cur(), ':') || toks.Peek() == nil ||
+		toks.Peek().Kind() != token.TOK_RBRACK {
+		return nil, errorAt(ERR_INVALID_POSIX_CLASS, toks, first, toks.Cur())
+	}
+	toks.Get()
+	ctx.event("set", "POSIX class %q, negated=%t", name.String(), negate)
+	ranges := match.PosixRanges(name.String(), false)
+	if ranges == nil {
+		return nil, errorAt(ERR_INVALID_POSIX_CLASS, toks, first, toks.Cur())
+	}
+	return ctx.classRanges(ranges, negate), nil
+}
+
+// property returns the name of the Unicode class of tok and whether it is
+// negated. Both \P{Greek} and \p{^Greek} are negations.
+func property(toks *token.Tokens, tok *token.Token) (string, bool, error) {
+	name, negate := tok.Text(), tok.Rune() == 'P'
+	if strings.HasPrefix(name, "^") {
+		name, negate = name[1:], !negate
+	}
+	if match.PropertyTable(name) == nil {
+		return "", false, errorAt(ERR_UNKNOWN_PROPERTY, toks, tok, tok)
+	}
+	return name, negate, nil
+}
+
+func (ctx *ctx) set(toks *token.Tokens, lbrack *token.Token) (ast.Node, error) {
 	ended, inverse := false, false
-	members := []match.Node{}
-	// Unless our set is meant to match the inverse ('^'), all our matchers
-	// match runes.
-	ctor := match.NewRune
+	ranges := []ast.Range{}
 	var prevRune rune
+	var prevTok *token.Token
+	// prevClass tells if the previous member was a class, which cannot
+	// begin a range.
+	prevClass := false
 	p := func(r rune) {
-		members = append(members, ctor(r))
+		prevTok = toks.Cur()
+		ranges = append(ranges, ast.Range{Lo: r, Hi: r})
 		prevRune = r
-
+		prevClass = false
 	}
-	replace := func(r rune, n match.Node) {
-		members = members[:len(members)-1]
-		members = append(members, n)
+	replace := func(r rune, rr ast.Range) {
+		ranges[len(ranges)-1] = rr
 		prevRune = r
 	}
-	fmt.Printf("-> set tokens start: %s\n", toks.Dump())
+	ctx.enter("set", toks.Cur())
+	ctx.event("set", "tokens start: %s", toks.Dump())
 	// As with POSIX ERE, the set contents are wanted as unmatched literal
 	// runes. This means that the position of '-', '^', and ']' matters.
 	gotFirstRune := false
@@ -47,7 +302,6 @@ func (ctx *ctx) set(toks *token.Tokens) (match.Node, error) {
 			// set.
 			if !gotFirstRune {
 				inverse = true
-				ctor = match.NewNotRune
 			} else {
 				p('^')
 			}
@@ -63,8 +317,43 @@ func (ctx *ctx) set(toks *token.Tokens) (match.Node, error) {
 		case token.TOK_RUNE:
 			gotFirstRune = true
 			p(toks.Cur().Rune())
+		case token.TOK_CLASS:
+			gotFirstRune = true
+			prevTok = toks.Cur()
+			prevClass = true
+			name := prevTok.Rune()
+			cr := ctx.classRanges(
+				match.ClassRanges(unicode.ToLower(name)), unicode.IsUpper(name))
+			ranges = append(ranges, astRanges(cr)...)
+		case token.TOK_PROPERTY:
+			gotFirstRune = true
+			prevTok = toks.Cur()
+			prevClass = true
+			name, negate, err := property(toks, prevTok)
+			if err != nil {
+				return nil, err
+			}
+			cr := ctx.classRanges(
+				match.TableRanges(match.PropertyTable(name), false), negate)
+			ranges = append(ranges, astRanges(cr)...)
+		case token.TOK_LBRACK:
+			gotFirstRune = true
+			if !isRune(toks.Peek(), ':') {
+				p('[')
+				break
+			}
+			prevTok = toks.Cur()
+			cr, err := ctx.posix(toks)
+			if err != nil {
+				return nil, err
+			}
+			prevClass = true
+			ranges = append(ranges, astRanges(cr)...)
 		case token.TOK_DASH:
-			if gotFirstRune {
+			// A '-' right before the closing ']' is literal, as in [\w-].
+			next := toks.Peek()
+			if gotFirstRune &&
+				(next == nil || next.Kind() != token.TOK_RBRACK) {
 				// To form a rune range, we need to make sure that we have
 				//   - a previous rune
 				//   - a next rune.
@@ -72,144 +361,207 @@ func (ctx *ctx) set(toks *token.Tokens) (match.Node, error) {
 				// At this point, we need to have at least the dash and the
 				// range end runes in our toks.
 				if toks.Count() < 2 {
-					return nil, fmt.Errorf("set range missing end")
+					return nil, errorAt(
+						ERR_SET_RANGE_MISSING_END, toks, prevTok, nil)
 				}
 				a := prevRune
 				toks.Get()
+				if k := toks.Cur().Kind(); prevClass ||
+					k == token.TOK_CLASS || k == token.TOK_PROPERTY {
+					return nil, errorAt(
+						ERR_SET_RANGE_CLASS, toks, prevTok, toks.Cur())
+				}
 				b := toks.Cur().Rune()
 				if a > b {
-					return nil, fmt.Errorf("set range not monotonic")
+					return nil, errorAt(
+						ERR_SET_RANGE_NOT_MONOTONIC, toks, prevTok, toks.Cur())
 				}
 				// Now, since we already previously pushed the range start to
-				// our matchers, we need to pop it off and replace it with the
-				// ranged matcher.
-				replace(b, match.NewRuneRange(a, b))
+				// our ranges, we need to replace it with the whole range.
+				replace(b, ast.Range{Lo: a, Hi: b})
 			} else {
 				p('-')
 			}
-		case token.TOK_DOT:
+		default:
+			// Everything else is literal within a set.
 			gotFirstRune = true
-			p('.')
+			p(toks.Cur().Rune())
 		}
 		toks.Get()
 	}
-	fmt.Printf("-> set tokens end: %s\n", toks.Dump())
+	ctx.event("set", "tokens end: %s", toks.Dump())
 	if !ended {
-		return nil, fmt.Errorf("rune set expression missing ']'")
-	}
-	if len(members) == 0 {
-		return nil, fmt.Errorf("rune set expression is empty")
-	}
-	var b match.Node
-	// There are two possibilities for building the set matcher:
-	//   - An inverse set, that is, match anything BUT the runes given
-	//   - A literal set, that is, match any of the runes
-	if len(members) == 1 {
-		b = members[0]
-	} else if inverse {
-		b = match.NewAll(members...)
-	} else {
-		b = match.NewAnyOf(members...)
+		return nil, errorAt(ERR_MISSING_RBRACK, toks, lbrack, nil)
+	}
+	if len(ranges) == 0 {
+		return nil, errorAt(ERR_EMPTY_SET, toks, lbrack, prevTok)
+	}
+	if ctx.flags.fold {
+		// The classes are folded already, but folding them again does
+		// not change them.
+		folded := []rune{}
+		for _, r := range ranges {
+			folded = append(folded, r.Lo, r.Hi)
+		}
+		ranges = astRanges(match.FoldRanges(folded))
+	}
+	b := &ast.CharClass{
+		Pos:    from(toks, lbrack),
+		Negate: inverse,
+		Ranges: ranges,
 	}
-	fmt.Printf("-> set expression:\n%s", match.Dump(b))
+	ctx.event("set", "expression:\n%s", ast.Dump(b))
 	return b, nil
 }
 
-func (ctx *ctx) atom(toks *token.Tokens) (match.Node, error) {
+func (ctx *ctx) atom(toks *token.Tokens) (ast.Node, error) {
 	tok := toks.Cur()
-	fmt.Printf("atom sees %s\n", tok)
+	ctx.enter("atom", tok)
 	switch tok.Kind() {
+	case token.TOK_CARET:
+		toks.Get()
+		kind := ast.ANCHOR_BEGIN
+		if ctx.flags.multiline {
+			kind = ast.ANCHOR_BEGIN_LINE
+		}
+		return &ast.Anchor{Pos: from(toks, tok), Kind: kind}, nil
 	case token.TOK_DOLLAR:
-		fmt.Printf("-> atom encountered %s, bailing\n", tok.Name())
+		if ctx.flags.multiline {
+			toks.Get()
+			return &ast.Anchor{
+				Pos: from(toks, tok), Kind: ast.ANCHOR_END_LINE}, nil
+		}
+		ctx.event("atom", "encountered %s, bailing", tok.Name())
 		return nil, bailDollar
 	case token.TOK_PIPE:
-		fmt.Printf("-> atom encountered %s, bailing\n", tok.Name())
+		ctx.event("atom", "encountered %s, bailing", tok.Name())
 		return nil, bailPipe
 	case token.TOK_RPAREN:
-		fmt.Printf("-> ')' -> pardepth=%d\n", ctx.pardepth)
-		if ctx.pardepth < 1 {
-			return nil, fmt.Errorf("unbalanced ')'")
+		ctx.event("atom", "')' -> pardepth=%d", len(ctx.parens))
+		if len(ctx.parens) < 1 {
+			return nil, errorAt(ERR_UNBALANCED_RPAREN, toks, nil, tok)
 		}
 		toks.Get()
-		ctx.pardepth--
+		ctx.parens = ctx.parens[:len(ctx.parens)-1]
 		return nil, bailNestedParens
 	case token.TOK_LPAREN:
+		if next := toks.Peek(); next != nil && next.Kind() == token.TOK_QU {
+			// A flag group matches nothing by itself unless it encloses
+			// something.
+			return ctx.flagGroup(toks)
+		}
 		toks.Get()
-		fmt.Printf("-> '(' -> pardepth=%d\n", ctx.pardepth)
-		ctx.pardepth++
-		return ctx.orexpr(toks)
+		return ctx.group(toks, tok, "")
 	case token.TOK_LBRACK:
 		toks.Get()
-		fmt.Println("-> '['")
-		return ctx.set(toks)
+		ctx.event("atom", "'['")
+		return ctx.set(toks, tok)
 	case token.TOK_DIGIT:
 		toks.Get()
-		fmt.Printf("-> matched digit '%c'\n", tok.Rune())
-		return match.NewRune(tok.Rune()), nil
+		ctx.event("atom", "matched digit '%c'", tok.Rune())
+		return &ast.Literal{Pos: from(toks, tok), Rune: tok.Rune()}, nil
 	case token.TOK_RUNE:
 		toks.Get()
-		fmt.Printf("-> matched rune '%c'\n", tok.Rune())
-		return match.NewRune(tok.Rune()), nil
+		ctx.event("atom", "matched rune '%c'", tok.Rune())
+		return &ast.Literal{
+			Pos: from(toks, tok), Rune: tok.Rune(), Fold: ctx.flags.fold}, nil
 	case token.TOK_DOT:
 		toks.Get()
-		return match.NewAny(), nil
+		if ctx.flags.dotall {
+			return &ast.CharClass{Pos: from(toks, tok), Negate: true}, nil
+		}
+		return &ast.CharClass{
+			Pos:    from(toks, tok),
+			Negate: true,
+			Ranges: []ast.Range{{Lo: '\n', Hi: '\n'}},
+		}, nil
+	case token.TOK_CLASS:
+		toks.Get()
+		ctx.event("atom", "matched class \\%c", tok.Rune())
+		if ctx.flags.fold {
+			// Folded classes are plain sets.
+			name := tok.Rune()
+			return &ast.CharClass{
+				Pos:    from(toks, tok),
+				Negate: unicode.IsUpper(name),
+				Ranges: astRanges(match.FoldRanges(
+					match.ClassRanges(unicode.ToLower(name)))),
+			}, nil
+		}
+		return &ast.Class{Pos: from(toks, tok), Name: tok.Rune()}, nil
+	case token.TOK_PROPERTY:
+		name, negate, err := property(toks, tok)
+		if err != nil {
+			return nil, err
+		}
+		toks.Get()
+		ctx.event("atom", "matched Unicode class %q", name)
+		if ctx.flags.fold {
+			return &ast.CharClass{
+				Pos:    from(toks, tok),
+				Negate: negate,
+				Ranges: astRanges(match.FoldRanges(
+					match.TableRanges(match.PropertyTable(name), false))),
+			}, nil
+		}
+		return &ast.Property{
+			Pos: from(toks, tok), Name: name, Negate: negate}, nil
 	case token.TOK_DASH:
 		toks.Get()
-		return match.NewRune('-'), nil
+		return &ast.Literal{Pos: from(toks, tok), Rune: '-'}, nil
 	default:
-		return nil, fmt.Errorf(
-			"unexpected atom: %s [%c]", tok.Name(), tok.Rune())
+		return nil, errorAt(ERR_UNEXPECTED_TOKEN, toks, nil, tok)
 	}
 }
 
-func (ctx *ctx) lengthrange(toks *token.Tokens) (match.TimesFunc, error) {
-	tok := toks.Get()
-	if tok.Kind() != token.TOK_LCURLY {
+func (ctx *ctx) lengthrange(toks *token.Tokens) (*ast.Repeat, error) {
+	lcurly := toks.Get()
+	if lcurly.Kind() != token.TOK_LCURLY {
 		panic("should not happen")
 	}
 	a := &strings.Builder{}
 	earlycurly := false
 	gotcomma := false
+	last := lcurly
 first_done:
 	for toks.Count() != 0 {
 		switch toks.Cur().Kind() {
 		case token.TOK_DIGIT:
 			a.WriteRune(toks.Cur().Rune())
-			toks.Get()
+			last = toks.Get()
 		case token.TOK_COMMA:
-			toks.Get()
+			last = toks.Get()
 			gotcomma = true
 			break first_done
 		case token.TOK_RCURLY:
-			toks.Get()
+			last = toks.Get()
 			earlycurly = true
 			break first_done
 		default:
-			return nil, fmt.Errorf(
-				"erroneous range length definition, got %s",
-				toks.Cur().Name())
+			return nil, errorAt(
+				ERR_INVALID_REPEAT, toks, lcurly, toks.Cur())
 		}
 	}
+	if !earlycurly && !gotcomma {
+		return nil, errorAt(ERR_UNTERMINATED_REPEAT, toks, lcurly, nil)
+	}
 	if a.Len() == 0 {
-		return nil, fmt.Errorf("invalid range length minimum")
+		return nil, errorAt(ERR_INVALID_REPEAT, toks, lcurly, last)
 	}
-	na, _ := strconv.Atoi(a.String())
-	// After we parsed the range start, we demand that either
-	//   - we do not have the range end at all, ie. it's unbound,
-	//     ie. we found '}' before ','
-	//   - if we did not '}', then we must have found ','
-	//
-	// We may end up here also due to tokens running out before either
-	// of these conditions was fulfilled.
+	// A count which overflows or exceeds REPEAT_MAX is invalid, and so is a
+	// range which ends before it begins. Counted repetitions are unrolled by
+	// the Pike VM, so they are kept small.
+	na, err := strconv.Atoi(a.String())
+	invalid := err != nil || na > ast.REPEAT_MAX
+	// After we parsed the range start, we have either
+	//   - no range end at all, ie. we found '}' before ','
+	//   - ',' after which the range end follows.
 	if earlycurly {
-		fmt.Printf("-> early curly of %d [%s]\n", na, a.String())
-		return func(n match.Node) match.Node {
-			return match.NewN(
-				n,
-				na)
-		}, nil
-	} else if !gotcomma {
-		return nil, fmt.Errorf("unterminated length range expression")
+		ctx.event("lengthrange", "early curly of %d [%s]", na, a.String())
+		if invalid {
+			return nil, errorAt(ERR_INVALID_REPEAT, toks, lcurly, last)
+		}
+		return &ast.Repeat{Min: na, Max: na}, nil
 	}
 
 	b := &strings.Builder{}
@@ -221,69 +573,91 @@ second_done:
 			b.WriteRune(toks.Cur().Rune())
 			toks.Get()
 		case token.TOK_RCURLY:
-			toks.Get()
+			last = toks.Get()
 			gotcurly = true
 			break second_done
 		default:
-			return nil, fmt.Errorf(
-				"erroneous range length maximum, got %s",
-				toks.Cur().Name())
+			return nil, errorAt(
+				ERR_INVALID_REPEAT, toks, lcurly, toks.Cur())
 		}
 	}
 	// Since the upper bound may only end after '}' is received, lack of it
 	// means an explicit syntax error.
 	if !gotcurly {
-		return nil, fmt.Errorf("unterminated range length")
+		return nil, errorAt(ERR_UNTERMINATED_REPEAT, toks, lcurly, nil)
 	}
-	var nb int
-	if b.Len() == 0 {
-		nb = match.RANGE_UNBOUND
-	} else {
-		nb, _ = strconv.Atoi(b.String())
+	nb := ast.REPEAT_UNBOUND
+	if b.Len() != 0 {
+		nb, err = strconv.Atoi(b.String())
+		invalid = invalid || err != nil || nb > ast.REPEAT_MAX || nb < na
 	}
-	return func(n match.Node) match.Node {
-		return match.NewLengthRange(
-			n,
-			na, nb)
-	}, nil
+	if invalid {
+		return nil, errorAt(ERR_INVALID_REPEAT, toks, lcurly, last)
+	}
+	return &ast.Repeat{Min: na, Max: nb}, nil
 }
 
-func (ctx *ctx) times(toks *token.Tokens) (match.TimesFunc, error) {
+// times returns the repetition which follows an atom, if any. The caller
+// fills in what is repeated. A '?' after the repetition makes it lazy, or
+// greedy if (?U) is in effect, and a '+' makes it possessive.
+func (ctx *ctx) times(toks *token.Tokens) (*ast.Repeat, error) {
 	tok := toks.Cur()
-	fmt.Printf("times with %s\n", tok)
-	var ret match.TimesFunc
+	ctx.enter("times", tok)
+	var ret *ast.Repeat
 	switch tok.Kind() {
 	case token.TOK_PLUS:
-		ret = match.NewOneOrMore
+		toks.Get()
+		ret = &ast.Repeat{Min: 1, Max: ast.REPEAT_UNBOUND}
 	case token.TOK_STAR:
-		ret = match.NewZeroOrMore
+		toks.Get()
+		ret = &ast.Repeat{Min: 0, Max: ast.REPEAT_UNBOUND}
 	case token.TOK_QU:
-		ret = match.NewZeroOrOne
+		toks.Get()
+		ret = &ast.Repeat{Min: 0, Max: 1}
 	case token.TOK_LCURLY:
-		return ctx.lengthrange(toks)
+		var err error
+		if ret, err = ctx.lengthrange(toks); err != nil {
+			return nil, err
+		}
 	default:
-		fmt.Println("-> no times")
+		ctx.event("times", "no times")
 		return nil, nil
 	}
-	toks.Get()
+	ret.Lazy = ctx.flags.ungreedy
+	switch next := toks.Cur(); {
+	case next == nil:
+	case next.Kind() == token.TOK_QU:
+		toks.Get()
+		ret.Lazy = !ret.Lazy
+		ctx.event("times", "lazy=%t", ret.Lazy)
+	case next.Kind() == token.TOK_PLUS:
+		toks.Get()
+		ret.Lazy, ret.Possessive = false, true
+		ctx.event("times", "possessive")
+	}
 	return ret, nil
 }
 
-func (ctx *ctx) atoms(toks *token.Tokens) (match.Node, error) {
-	ret := []match.Node{}
+func (ctx *ctx) atoms(toks *token.Tokens) (ast.Node, error) {
+	ret := []ast.Node{}
+	at0 := column(toks)
 	var reterr error
 away:
 	for toks.Count() != 0 {
-		fmt.Printf("atoms with %s\n", toks.Cur())
+		ctx.enter("atoms", toks.Cur())
 		at, err := ctx.atom(toks)
 		switch err {
 		case nil:
 		case bailNestedParens, bailPipe, bailDollar:
-			fmt.Println("-> atoms breaking off: ", err)
+			ctx.event("atoms", "breaking off: %v", err)
 			reterr = err
 			break away
 		default:
-			return nil, fmt.Errorf("atoms: %w", err)
+			return nil, err
+		}
+		if at == nil {
+			// Flags were set.
+			continue
 		}
 		if toks.Count() == 0 {
 			ret = append(ret, at)
@@ -293,44 +667,48 @@ away:
 		if err != nil {
 			return nil, err
 		} else if ti != nil {
-			fmt.Println("-> yes times for atoms")
-			ret = append(ret, ti(at))
+			ctx.event("atoms", "yes times")
+			ti.Sub = at
+			ti.Pos = ast.Pos{
+				Column: at.Position().Column,
+				End:    toks.Prev().Column() + toks.Prev().Width(),
+			}
+			ret = append(ret, ti)
 		} else {
-			fmt.Println("-> no times for atoms")
+			ctx.event("atoms", "no times")
 			ret = append(ret, at)
 		}
 	}
 	if len(ret) == 1 {
 		return ret[0], reterr
 	} else {
-		return match.NewAll(ret...), reterr
+		return &ast.Concat{Pos: span(ret, at0), Subs: ret}, reterr
 	}
 }
 
-func (ctx *ctx) orexpr(toks *token.Tokens) (match.Node, error) {
-	id := ctx.ncapturers
-	ctx.ncapturers++
-	all := [][]match.Node{[]match.Node{}}
-	push := func(n match.Node) {
+func (ctx *ctx) orexpr(toks *token.Tokens) (ast.Node, error) {
+	at0 := column(toks)
+	all := [][]ast.Node{[]ast.Node{}}
+	push := func(n ast.Node) {
 		ci := len(all) - 1
 		all[ci] = append(all[ci], n)
 	}
 	moreor := func() {
-		all = append(all, []match.Node{})
+		all = append(all, []ast.Node{})
 	}
 away:
 	for toks.Count() != 0 {
-		fmt.Printf("orexpr with %s\n", toks.Cur())
+		ctx.enter("orexpr", toks.Cur())
 		ats, err := ctx.atoms(toks)
 		switch err {
 		case nil, bailPipe:
 			push(ats)
 		case bailNestedParens, bailDollar:
-			fmt.Println("-> orexpr bailing: ", err)
+			ctx.event("orexpr", "bailing: %v", err)
 			push(ats)
 			break away
 		default:
-			return nil, fmt.Errorf("orexpr with atoms failed: %v", err)
+			return nil, err
 		}
 		// We rely on the lower level `atom' matcher to kick back here if a '|'
 		// is encountered on a suitable place.
@@ -339,78 +717,103 @@ away:
 			moreor()
 		}
 	}
-	fmt.Printf("-> orexpr gave %d slices\n", len(all))
+	// An expression ending right after a '|' has an empty last branch.
+	if last := all[len(all)-1]; len(all) > 1 && len(last) == 0 {
+		push(&ast.Concat{Pos: span(nil, column(toks)), Subs: []ast.Node{}})
+	}
+	ctx.event("orexpr", "gave %d slices", len(all))
 	// We have two possibilities here:
 	//
 	//   1) No '|' encountered, ie. a single slice of matchers in all[0], or
 	//   2) '|' cases, which means several alternative cases.
 	//
-	var ret match.Node
+	var ret ast.Node
 	if len(all) == 1 {
-		// Optimize the single matcher case again, and avoid redundant `All'
+		// Optimize the single node case again, and avoid redundant `Concat'
 		// wrapping.
 		if len(all[0]) == 1 {
 			ret = all[0][0]
 		} else {
-			ret = match.NewAll(all[0]...)
+			ret = &ast.Concat{Pos: span(all[0], at0), Subs: all[0]}
 		}
 	} else {
-		alls := []match.Node{}
+		alls := []ast.Node{}
 		for i := 0; i < len(all); i++ {
 			alls = append(alls, all[i]...)
 		}
-		ret = match.NewAnyOf(alls...)
+		ret = &ast.Alternate{Pos: span(alls, at0), Subs: alls}
 	}
-	return match.NewCapture(ret, id), nil
+	return ret, nil
 }
 
-func (ctx *ctx) regexp(toks *token.Tokens) (match.Node, error) {
+// regexp parses the whole expression. If it has anchors, the result is a
+// Concat of the anchors and the expression between them.
+func (ctx *ctx) regexp(toks *token.Tokens) (ast.Node, error) {
 	if toks.Count() == 0 {
-		return nil, fmt.Errorf("no regexp")
+		return nil, errorAt(ERR_EMPTY, toks, nil, nil)
 	}
-	gotCaret, gotDollar := false, false
-	if toks.Cur().Kind() == token.TOK_CARET {
+	ret := []ast.Node{}
+	if tok := toks.Cur(); tok.Kind() == token.TOK_CARET {
 		toks.Get()
-		gotCaret = true
+		ret = append(ret, &ast.Anchor{
+			Pos: from(toks, tok), Kind: ast.ANCHOR_BEGIN})
 	}
 	re, err := ctx.orexpr(toks)
 	if err != nil {
 		return nil, err
 	}
-	if toks.Count() > 0 && toks.Cur().Kind() == token.TOK_DOLLAR {
+	ret = append(ret, re)
+	if tok := toks.Cur(); tok != nil && tok.Kind() == token.TOK_DOLLAR {
 		toks.Get()
-		gotDollar = true
 		if toks.Count() > 0 {
-			return nil, fmt.Errorf("regexp does not end at '$'")
+			return nil, errorAt(ERR_TRAILING_DOLLAR, toks, nil, toks.Cur())
 		}
+		ret = append(ret, &ast.Anchor{
+			Pos: from(toks, tok), Kind: ast.ANCHOR_END})
 	}
-	var ret match.Node
-	switch {
-	case !gotCaret && !gotDollar:
-		ret = match.NewScanTry(re)
-	case gotCaret && gotDollar:
-		// ^..$
-		ret = match.NewExhaustive(re)
-	case gotCaret && !gotDollar:
-		// ^..
-		ret = re
-	default:
-		// ..$
-		ret = match.NewExhaustive(match.NewScanTry(re))
+	if len(ret) == 1 {
+		return re, nil
 	}
-	return ret, nil
+	return &ast.Concat{Pos: span(ret, 1), Subs: ret}, nil
+}
+
+func Compile(toks *token.Tokens) (*match.Root, error) {
+	return CompileWith(toks, &Options{})
 }
 
-func Compile(toks *token.Tokens) (*match.Context, *match.Root, error) {
-	ctx := &ctx{ncapturers: 0}
+// CompileWith parses toks and lowers the syntax tree into a matcher tree.
+func CompileWith(toks *token.Tokens, opts *Options) (*match.Root, error) {
+	n, err := Parse(toks, opts)
+	if err != nil {
+		return nil, err
+	}
+	return Lower(n), nil
+}
+
+// Parse builds the syntax tree of toks.
+func Parse(toks *token.Tokens, opts *Options) (ast.Node, error) {
+	// Capture index zero is reserved for the whole expression.
+	ctx := &ctx{
+		ncapturers: 1,
+		names:      map[string]bool{},
+		tracer:     opts.Tracer,
+		flags:      flagSet{fold: opts.CaseInsensitive},
+	}
 
 	if toks.Count() == 0 {
-		return nil, nil, fmt.Errorf("no tokens to compile")
+		return nil, errorAt(ERR_EMPTY, toks, nil, nil)
+	}
+	// Malformed escapes are reported before anything else.
+	if tok := toks.First(token.TOK_INVALID); tok != nil {
+		return nil, errorAt(ERR_INVALID_ESCAPE, toks, tok, tok)
 	}
 	re, err := ctx.regexp(toks)
 	if err != nil {
-		return nil, nil, fmt.Errorf("%v", err)
+		return nil, err
+	}
+	if len(ctx.parens) > 0 {
+		return nil, errorAt(
+			ERR_MISSING_RPAREN, toks, ctx.parens[len(ctx.parens)-1], nil)
 	}
-	mctx := match.NewContext(ctx.ncapturers)
-	return mctx, match.NewRoot(re).(*match.Root), nil
+	return re, nil
 }
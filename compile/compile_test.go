@@ -3,10 +3,13 @@ package compile_test
 import (
 	"reflect"
 	"testing"
+	"unicode"
 
+	"github.com/susji/mre/ast"
 	"github.com/susji/mre/compile"
 	"github.com/susji/mre/lex"
 	"github.com/susji/mre/match"
+	"github.com/susji/mre/token"
 )
 
 func TestCompileBasic(t *testing.T) {
@@ -122,9 +125,9 @@ func TestCompileBasic(t *testing.T) {
 			test: "^[^ab]",
 			exp: match.NewRoot(
 				match.NewCapture(
-					match.NewAll(
-						match.NewNotRune('a'),
-						match.NewNotRune('b')), 0)),
+					match.NewNoneOf(
+						match.NewRune('a'),
+						match.NewRune('b')), 0)),
 		},
 
 		{
@@ -162,7 +165,7 @@ func TestCompileBasic(t *testing.T) {
 
 	for _, te := range table {
 		t.Run(te.test, func(t *testing.T) {
-			_, root, err := compile.Compile(lex.Lex(te.test))
+			root, err := compile.Compile(lex.Lex(te.test))
 			if err != nil {
 				t.Error("errored: ", err)
 				return
@@ -178,3 +181,167 @@ func TestCompileBasic(t *testing.T) {
 		})
 	}
 }
+
+type recorder struct {
+	rules  []string
+	events int
+}
+
+func (r *recorder) Enter(rule string, tok *token.Token) {
+	r.rules = append(r.rules, rule+" "+tok.String())
+}
+
+func (r *recorder) Event(rule, what string) {
+	r.events++
+}
+
+func TestTracer(t *testing.T) {
+	r := &recorder{}
+	_, err := compile.CompileWith(
+		lex.Lex("a+"), &compile.Options{Tracer: r})
+	if err != nil {
+		t.Fatal("errored: ", err)
+	}
+	exp := []string{"orexpr 'a'", "atoms 'a'", "atom 'a'", "times +"}
+	if !reflect.DeepEqual(r.rules, exp) {
+		t.Errorf("wanted rules %#v, got %#v", exp, r.rules)
+	}
+	if r.events == 0 {
+		t.Error("no events")
+	}
+}
+
+func TestParse(t *testing.T) {
+	type entry struct {
+		test string
+		exp  ast.Node
+	}
+	pos := func(column, end uint) ast.Pos {
+		return ast.Pos{Column: column, End: end}
+	}
+
+	table := []entry{
+		{
+			test: "ab+",
+			exp: &ast.Concat{Pos: pos(1, 4), Subs: []ast.Node{
+				&ast.Literal{Pos: pos(1, 2), Rune: 'a'},
+				&ast.Repeat{
+					Pos: pos(2, 4),
+					Sub: &ast.Literal{Pos: pos(2, 3), Rune: 'b'},
+					Min: 1, Max: ast.REPEAT_UNBOUND}}},
+		},
+		{
+			test: "^(a|.)$",
+			exp: &ast.Concat{Pos: pos(1, 8), Subs: []ast.Node{
+				&ast.Anchor{Pos: pos(1, 2), Kind: ast.ANCHOR_BEGIN},
+				&ast.Group{Pos: pos(2, 7), Index: 1, Sub: &ast.Alternate{
+					Pos: pos(3, 6), Subs: []ast.Node{
+						&ast.Literal{Pos: pos(3, 4), Rune: 'a'},
+						&ast.CharClass{
+							Pos:    pos(5, 6),
+							Negate: true,
+							Ranges: []ast.Range{{Lo: '\n', Hi: '\n'}}}}}},
+				&ast.Anchor{Pos: pos(7, 8), Kind: ast.ANCHOR_END}}},
+		},
+		{
+			test: `[^\]a-z]{2,}`,
+			exp: &ast.Repeat{
+				Pos: pos(1, 13),
+				Sub: &ast.CharClass{
+					Pos: pos(1, 9), Negate: true, Ranges: []ast.Range{
+						{Lo: ']', Hi: ']'}, {Lo: 'a', Hi: 'z'}}},
+				Min: 2, Max: ast.REPEAT_UNBOUND},
+		},
+		{
+			test: `\d[x\S]`,
+			exp: &ast.Concat{Pos: pos(1, 8), Subs: []ast.Node{
+				&ast.Class{Pos: pos(1, 3), Name: 'd'},
+				&ast.CharClass{Pos: pos(3, 8), Ranges: []ast.Range{
+					{Lo: 'x', Hi: 'x'},
+					{Lo: 0, Hi: '\t' - 1},
+					{Lo: '\n' + 1, Hi: '\f' - 1},
+					{Lo: '\r' + 1, Hi: ' ' - 1},
+					{Lo: ' ' + 1, Hi: unicode.MaxRune}}}}},
+		},
+		{
+			test: "(?i)a[b]",
+			exp: &ast.Concat{Pos: pos(5, 9), Subs: []ast.Node{
+				&ast.Literal{Pos: pos(5, 6), Rune: 'a', Fold: true},
+				&ast.CharClass{Pos: pos(6, 9), Ranges: []ast.Range{
+					{Lo: 'B', Hi: 'B'}, {Lo: 'b', Hi: 'b'}}}}},
+		},
+		{
+			test: "x(?i:a)",
+			exp: &ast.Concat{Pos: pos(1, 8), Subs: []ast.Node{
+				&ast.Literal{Pos: pos(1, 2), Rune: 'x'},
+				&ast.Group{Pos: pos(2, 8), Sub: &ast.Literal{
+					Pos: pos(6, 7), Rune: 'a', Fold: true}}}},
+		},
+		{
+			test: "(?:a|b)+(c)",
+			exp: &ast.Concat{Pos: pos(1, 12), Subs: []ast.Node{
+				&ast.Repeat{
+					Pos: pos(1, 9),
+					Sub: &ast.Group{Pos: pos(1, 8), Sub: &ast.Alternate{
+						Pos: pos(4, 7), Subs: []ast.Node{
+							&ast.Literal{Pos: pos(4, 5), Rune: 'a'},
+							&ast.Literal{Pos: pos(6, 7), Rune: 'b'}}}},
+					Min: 1, Max: ast.REPEAT_UNBOUND},
+				&ast.Group{Pos: pos(9, 12), Index: 1, Sub: &ast.Literal{
+					Pos: pos(10, 11), Rune: 'c'}}}},
+		},
+		{
+			test: "a|",
+			exp: &ast.Alternate{Pos: pos(1, 3), Subs: []ast.Node{
+				&ast.Literal{Pos: pos(1, 2), Rune: 'a'},
+				&ast.Concat{Pos: pos(3, 3), Subs: []ast.Node{}}}},
+		},
+		{
+			test: "(?P<x>a)(?<y_1>)",
+			exp: &ast.Concat{Pos: pos(1, 17), Subs: []ast.Node{
+				&ast.Group{Pos: pos(1, 9), Index: 1, Name: "x", Sub: &ast.Literal{
+					Pos: pos(7, 8), Rune: 'a'}},
+				&ast.Group{Pos: pos(9, 17), Index: 2, Name: "y_1", Sub: &ast.Concat{
+					Pos: pos(16, 16), Subs: []ast.Node{}}}}},
+		},
+		{
+			test: "a*?(?U)b{2,}?c+",
+			exp: &ast.Concat{Pos: pos(1, 16), Subs: []ast.Node{
+				&ast.Repeat{Pos: pos(1, 4), Min: 0, Max: ast.REPEAT_UNBOUND,
+					Lazy: true, Sub: &ast.Literal{Pos: pos(1, 2), Rune: 'a'}},
+				&ast.Repeat{Pos: pos(8, 14), Min: 2, Max: ast.REPEAT_UNBOUND,
+					Sub: &ast.Literal{Pos: pos(8, 9), Rune: 'b'}},
+				&ast.Repeat{Pos: pos(14, 16), Min: 1, Max: ast.REPEAT_UNBOUND,
+					Lazy: true, Sub: &ast.Literal{Pos: pos(14, 15), Rune: 'c'}}}},
+		},
+		{
+			test: "(?>a)b{2}+",
+			exp: &ast.Concat{Pos: pos(1, 11), Subs: []ast.Node{
+				&ast.Atomic{Pos: pos(1, 6), Sub: &ast.Literal{
+					Pos: pos(4, 5), Rune: 'a'}},
+				&ast.Repeat{Pos: pos(6, 11), Min: 2, Max: 2, Possessive: true,
+					Sub: &ast.Literal{Pos: pos(6, 7), Rune: 'b'}}}},
+		},
+		{
+			test: "a|()",
+			exp: &ast.Alternate{Pos: pos(1, 5), Subs: []ast.Node{
+				&ast.Literal{Pos: pos(1, 2), Rune: 'a'},
+				&ast.Group{Pos: pos(3, 5), Index: 1, Sub: &ast.Concat{
+					Pos: pos(4, 4), Subs: []ast.Node{}}}}},
+		},
+	}
+
+	for _, te := range table {
+		t.Run(te.test, func(t *testing.T) {
+			n, err := compile.Parse(lex.Lex(te.test), &compile.Options{})
+			if err != nil {
+				t.Fatal("errored: ", err)
+			}
+			if !reflect.DeepEqual(n, te.exp) {
+				t.Error("not equal")
+				t.Log("wanted:\n", ast.Dump(te.exp))
+				t.Log("got:\n", ast.Dump(n))
+			}
+		})
+	}
+}
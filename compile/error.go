@@ -0,0 +1,94 @@
+package compile
+
+import (
+	"fmt"
+
+	"github.com/susji/mre/token"
+)
+
+// ErrorCode tells what kind of a syntax error was found.
+type ErrorCode uint8
+
+const (
+	ERR_EMPTY = ErrorCode(iota)
+	ERR_UNEXPECTED_TOKEN
+	ERR_UNBALANCED_RPAREN
+	ERR_MISSING_RPAREN
+	ERR_MISSING_RBRACK
+	ERR_EMPTY_SET
+	ERR_SET_RANGE_MISSING_END
+	ERR_SET_RANGE_NOT_MONOTONIC
+	ERR_INVALID_REPEAT
+	ERR_UNTERMINATED_REPEAT
+	ERR_TRAILING_DOLLAR
+	ERR_SET_RANGE_CLASS
+	ERR_INVALID_ESCAPE
+	ERR_INVALID_POSIX_CLASS
+	ERR_UNKNOWN_PROPERTY
+	ERR_INVALID_FLAGS
+	ERR_INVALID_GROUP_NAME
+	ERR_DUPLICATE_GROUP_NAME
+)
+
+var ErrorCodeNames = []string{
+	"nothing to compile",
+	"unexpected token",
+	"unbalanced ')'",
+	"missing ')'",
+	"rune set expression missing ']'",
+	"rune set expression is empty",
+	"set range missing end",
+	"set range not monotonic",
+	"invalid length range",
+	"unterminated length range",
+	"regexp does not end at '$'",
+	"set range bound is a class",
+	"invalid escape sequence",
+	"invalid POSIX class",
+	"unknown Unicode class",
+	"invalid flags",
+	"invalid group name",
+	"duplicate group name",
+}
+
+func (c ErrorCode) String() string {
+	return ErrorCodeNames[c]
+}
+
+// Error is a syntax error found while compiling. The problem spans the
+// expression from column Column up to but not including column End. Columns
+// are counted in runes and begin from one.
+type Error struct {
+	Code ErrorCode
+	// Tok is the offending token. It is nil if the expression ended too
+	// early.
+	Tok         *token.Token
+	Column, End uint
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at column %d", e.Code, e.Column)
+}
+
+// errorAt returns an error spanning the tokens from first to last. If last is
+// nil, the error spans up to the end of the expression.
+func errorAt(code ErrorCode, toks *token.Tokens, first, last *token.Token) *Error {
+	e := &Error{Code: code, Tok: last}
+	switch {
+	case first != nil:
+		e.Column = first.Column()
+	case last != nil:
+		e.Column = last.Column()
+	default:
+		e.Column = toks.End()
+	}
+	if last != nil {
+		e.End = last.Column() + last.Width()
+	} else {
+		e.End = toks.End()
+	}
+	if e.End <= e.Column {
+		e.End = e.Column + 1
+	}
+	return e
+}
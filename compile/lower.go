@@ -0,0 +1,144 @@
+package compile
+
+import (
+	"fmt"
+
+	"github.com/susji/mre/ast"
+	"github.com/susji/mre/match"
+)
+
+// Lower builds the matcher tree of a syntax tree. The whole expression is
+// captured with index zero. Unless anchored to the beginning, the expression
+// is tried from every position of the input.
+func Lower(n ast.Node) *match.Root {
+	gotCaret, gotDollar := false, false
+	if c, ok := n.(*ast.Concat); ok && len(c.Subs) > 0 {
+		subs := c.Subs
+		if a, ok := subs[0].(*ast.Anchor); ok && a.Kind == ast.ANCHOR_BEGIN {
+			gotCaret = true
+			subs = subs[1:]
+		}
+		if len(subs) > 0 {
+			a, ok := subs[len(subs)-1].(*ast.Anchor)
+			if ok && a.Kind == ast.ANCHOR_END {
+				gotDollar = true
+				subs = subs[:len(subs)-1]
+			}
+		}
+		if gotCaret || gotDollar {
+			if len(subs) == 1 {
+				n = subs[0]
+			} else {
+				n = &ast.Concat{Pos: c.Pos, Subs: subs}
+			}
+		}
+	}
+	re := match.NewCapture(lower(n), 0)
+	var ret match.Node
+	switch {
+	case !gotCaret && !gotDollar:
+		ret = match.NewScanTry(re)
+	case gotCaret && gotDollar:
+		// ^..$
+		ret = match.NewExhaustive(re)
+	case gotCaret && !gotDollar:
+		// ^..
+		ret = re
+	default:
+		// ..$
+		ret = match.NewExhaustive(match.NewScanTry(re))
+	}
+	return match.NewRoot(ret).(*match.Root)
+}
+
+func lowerAll(nodes []ast.Node) []match.Node {
+	ret := make([]match.Node, len(nodes))
+	for i, n := range nodes {
+		ret[i] = lower(n)
+	}
+	return ret
+}
+
+func lower(n ast.Node) match.Node {
+	switch v := n.(type) {
+	case *ast.Literal:
+		if !v.Fold {
+			return match.NewRune(v.Rune)
+		}
+		runes := match.Fold(v.Rune)
+		if len(runes) == 1 {
+			return match.NewRune(v.Rune)
+		}
+		members := make([]match.Node, len(runes))
+		for i, r := range runes {
+			members[i] = match.NewRune(r)
+		}
+		return match.NewAnyOf(members...)
+	case *ast.CharClass:
+		if v.Negate && len(v.Ranges) == 0 {
+			return match.NewAny()
+		}
+		members := make([]match.Node, len(v.Ranges))
+		for i, r := range v.Ranges {
+			if r.Lo == r.Hi {
+				members[i] = match.NewRune(r.Lo)
+			} else {
+				members[i] = match.NewRuneRange(r.Lo, r.Hi)
+			}
+		}
+		// There are two possibilities for building the set matcher:
+		//   - An inverse set, that is, match anything BUT the runes given
+		//   - A literal set, that is, match any of the runes
+		if v.Negate {
+			return match.NewNoneOf(members...)
+		} else if len(members) == 1 {
+			return members[0]
+		}
+		return match.NewAnyOf(members...)
+	case *ast.Class:
+		return match.NewClass(v.Name)
+	case *ast.Property:
+		return match.NewProperty(v.Name, v.Negate)
+	case *ast.Concat:
+		return match.NewAll(lowerAll(v.Subs)...)
+	case *ast.Alternate:
+		return match.NewAnyOf(lowerAll(v.Subs)...)
+	case *ast.Repeat:
+		sub := lower(v.Sub)
+		if v.Lazy && v.Min != v.Max {
+			return match.NewLazy(sub, v.Min, v.Max)
+		}
+		var ret match.Node
+		switch {
+		case v.Min == 0 && v.Max == ast.REPEAT_UNBOUND:
+			ret = match.NewZeroOrMore(sub)
+		case v.Min == 1 && v.Max == ast.REPEAT_UNBOUND:
+			ret = match.NewOneOrMore(sub)
+		case v.Min == 0 && v.Max == 1:
+			ret = match.NewZeroOrOne(sub)
+		case v.Min == v.Max:
+			ret = match.NewN(sub, v.Min)
+		default:
+			ret = match.NewLengthRange(sub, v.Min, v.Max)
+		}
+		if v.Possessive {
+			return match.NewAtomic(ret)
+		}
+		return ret
+	case *ast.Atomic:
+		return match.NewAtomic(lower(v.Sub))
+	case *ast.Group:
+		if v.Index == 0 {
+			return lower(v.Sub)
+		}
+		return match.NewCapture(lower(v.Sub), v.Index)
+	case *ast.Anchor:
+		return match.NewAssert([]match.AssertKind{
+			match.ASSERT_BEGIN,
+			match.ASSERT_END,
+			match.ASSERT_BEGIN_LINE,
+			match.ASSERT_END_LINE,
+		}[v.Kind])
+	}
+	panic(fmt.Sprintf("missing case for %T", n))
+}
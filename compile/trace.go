@@ -0,0 +1,47 @@
+package compile
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/susji/mre/token"
+)
+
+// Tracer follows the parser as it goes through an expression. It is meant
+// for debugging expressions.
+type Tracer interface {
+	// Enter is called when the parser begins the grammar rule with tok as
+	// the next token.
+	Enter(rule string, tok *token.Token)
+	// Event is called when something of note happens in the grammar rule.
+	Event(rule, what string)
+}
+
+type writerTracer struct {
+	w io.Writer
+}
+
+// NewWriterTracer returns a Tracer which writes the trace to w line by line.
+func NewWriterTracer(w io.Writer) Tracer {
+	return &writerTracer{w}
+}
+
+func (wt *writerTracer) Enter(rule string, tok *token.Token) {
+	fmt.Fprintf(wt.w, "%s sees %s\n", rule, tok)
+}
+
+func (wt *writerTracer) Event(rule, what string) {
+	fmt.Fprintf(wt.w, "-> %s: %s\n", rule, what)
+}
+
+func (ctx *ctx) enter(rule string, tok *token.Token) {
+	if ctx.tracer != nil {
+		ctx.tracer.Enter(rule, tok)
+	}
+}
+
+func (ctx *ctx) event(rule, format string, args ...interface{}) {
+	if ctx.tracer != nil {
+		ctx.tracer.Event(rule, fmt.Sprintf(format, args...))
+	}
+}
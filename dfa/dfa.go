@@ -0,0 +1,285 @@
+// Package dfa answers whether a program matches without tracking captures.
+// Deterministic states are built lazily out of the NFA program as the input
+// is scanned and kept in a bounded cache, so each rune costs a lookup in the
+// common case.
+package dfa
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/susji/mre/input"
+	"github.com/susji/mre/vm"
+)
+
+// DEFAULT_MAX_STATES is a sensible state cache size for most expressions.
+const DEFAULT_MAX_STATES = 4096
+
+// We give up on the DFA if the cache has been flushed this many times during
+// one match and it still does not get much use between flushes.
+const (
+	maxFlushes      = 3
+	minRunesByState = 10
+)
+
+// ErrFallback is returned when the state cache thrashes or the program asserts
+// line boundaries, which depend on more than the state. The caller should use
+// another engine for the input.
+var ErrFallback = errors.New("dfa: cannot run program")
+
+type state struct {
+	// insts has the sorted program counters of the threads waiting for a
+	// rune.
+	insts []int
+	// ends has the program counters of end-of-input assertions reached.
+	ends []int
+	// match tells if a match was reached already, and matchAtEnd if one is
+	// reached when there is no input left.
+	match, matchAtEnd bool
+	// exprs and exprsAtEnd have the same for each expression of a program
+	// compiled out of a set: the indices of the expressions whose matches
+	// were reached.
+	exprs, exprsAtEnd []int
+	ascii             [128]*state
+	other             map[rune]*state
+}
+
+// DFA holds the lazily built states of a program. It is not safe for
+// concurrent use.
+type DFA struct {
+	prog      *vm.Prog
+	maxStates int
+	states    map[string]*state
+	start     *state
+	// visited is scratch space for computing closures.
+	visited []bool
+	// lines tells if the program has line assertions.
+	lines bool
+}
+
+func New(prog *vm.Prog, maxStates int) *DFA {
+	d := &DFA{
+		prog:      prog,
+		maxStates: maxStates,
+		states:    map[string]*state{},
+		visited:   make([]bool, len(prog.Inst)),
+	}
+	for _, inst := range prog.Inst {
+		if inst.Op == vm.OP_BEGIN_LINE || inst.Op == vm.OP_END_LINE {
+			d.lines = true
+		}
+	}
+	return d
+}
+
+// closure follows the empty transitions from pc. Runes and end-of-input
+// assertions are collected into s, if atEnd is false. Otherwise the end
+// assertions pass and we only look for a match. Beginning-of-input assertions
+// pass only for the start state.
+func (d *DFA) closure(s *state, pc int, atBegin, atEnd bool) {
+	if d.visited[pc] {
+		return
+	}
+	d.visited[pc] = true
+	inst := &d.prog.Inst[pc]
+	switch inst.Op {
+	case vm.OP_JMP, vm.OP_SAVE:
+		d.closure(s, inst.Out, atBegin, atEnd)
+	case vm.OP_SPLIT:
+		d.closure(s, inst.Out, atBegin, atEnd)
+		d.closure(s, inst.Arg, atBegin, atEnd)
+	case vm.OP_BEGIN:
+		if atBegin {
+			d.closure(s, inst.Out, atBegin, atEnd)
+		}
+	case vm.OP_END:
+		if atEnd {
+			d.closure(s, inst.Out, atBegin, atEnd)
+		} else {
+			s.ends = append(s.ends, pc)
+		}
+	case vm.OP_MATCH:
+		if atEnd {
+			s.matchAtEnd = true
+			s.exprsAtEnd = append(s.exprsAtEnd, inst.Arg)
+		} else {
+			s.match = true
+			s.exprs = append(s.exprs, inst.Arg)
+		}
+	case vm.OP_RUNES, vm.OP_ANY, vm.OP_TABLE:
+		if !atEnd {
+			s.insts = append(s.insts, pc)
+		}
+	}
+}
+
+func (d *DFA) clearVisited() {
+	for i := range d.visited {
+		d.visited[i] = false
+	}
+}
+
+// build computes the state reached by following the given program counters
+// and returns the cached copy of it, if we have one.
+func (d *DFA) build(pcs []int, atBegin bool) *state {
+	s := &state{}
+	d.clearVisited()
+	for _, pc := range pcs {
+		d.closure(s, pc, atBegin, false)
+	}
+	sort.Ints(s.insts)
+	sort.Ints(s.ends)
+	sort.Ints(s.exprs)
+	b := &strings.Builder{}
+	for _, pc := range s.insts {
+		b.WriteString(strconv.Itoa(pc))
+		b.WriteByte(',')
+	}
+	b.WriteByte('|')
+	for _, pc := range s.ends {
+		b.WriteString(strconv.Itoa(pc))
+		b.WriteByte(',')
+	}
+	b.WriteByte('|')
+	for _, e := range s.exprs {
+		b.WriteString(strconv.Itoa(e))
+		b.WriteByte(',')
+	}
+	key := b.String()
+	if cached, ok := d.states[key]; ok {
+		return cached
+	}
+	s.matchAtEnd = s.match
+	s.exprsAtEnd = append([]int{}, s.exprs...)
+	d.clearVisited()
+	for _, pc := range s.ends {
+		d.closure(s, d.prog.Inst[pc].Out, atBegin, true)
+	}
+	d.states[key] = s
+	return s
+}
+
+func (d *DFA) flush() {
+	d.states = map[string]*state{}
+	d.start = nil
+}
+
+// step returns the state following s after reading r.
+func (d *DFA) step(s *state, r rune) *state {
+	if r >= 0 && r < 128 {
+		if next := s.ascii[r]; next != nil {
+			return next
+		}
+	} else if next, ok := s.other[r]; ok {
+		return next
+	}
+	pcs := []int{}
+	for _, pc := range s.insts {
+		inst := &d.prog.Inst[pc]
+		if inst.MatchRune(r) {
+			pcs = append(pcs, inst.Out)
+		}
+	}
+	next := d.build(pcs, false)
+	if r >= 0 && r < 128 {
+		s.ascii[r] = next
+	} else {
+		if s.other == nil {
+			s.other = map[rune]*state{}
+		}
+		s.other[r] = next
+	}
+	return next
+}
+
+// thrash counts how the state cache is used during one match.
+type thrash struct {
+	flushes, sinceFlush int
+}
+
+// next is like step, but it first flushes the state cache if it is full. If
+// the cache thrashes, ErrFallback is returned.
+func (d *DFA) next(s *state, r rune, t *thrash) (*state, error) {
+	if len(d.states) >= d.maxStates {
+		t.flushes++
+		if t.flushes >= maxFlushes &&
+			t.sinceFlush < minRunesByState*d.maxStates {
+			return nil, ErrFallback
+		}
+		t.sinceFlush = 0
+		// The current state is rebuilt into the fresh cache.
+		d.flush()
+		s = d.build(append(append([]int{}, s.insts...), s.ends...), false)
+	}
+	t.sinceFlush++
+	return d.step(s, r), nil
+}
+
+// Match reports whether the program matches anywhere it is allowed to in
+// in. If the state cache thrashes or the program asserts line boundaries,
+// ErrFallback is returned.
+func (d *DFA) Match(in input.Input) (bool, error) {
+	if d.lines {
+		return false, ErrFallback
+	}
+	if d.start == nil {
+		d.start = d.build([]int{0}, true)
+	}
+	s, t := d.start, &thrash{}
+	for pos := 0; ; {
+		r, w := in.Step(pos)
+		if w == 0 {
+			break
+		}
+		pos += w
+		if s.match {
+			return true, nil
+		}
+		if len(s.insts) == 0 {
+			return false, nil
+		}
+		var err error
+		if s, err = d.next(s, r, t); err != nil {
+			return false, err
+		}
+	}
+	return s.match || s.matchAtEnd, nil
+}
+
+// MatchSet runs a program compiled out of a set of expressions over in and
+// returns the sorted indices of the expressions which match, or nil if none
+// does. If the state cache thrashes or the program asserts line boundaries,
+// ErrFallback is returned.
+func (d *DFA) MatchSet(in input.Input) ([]int, error) {
+	if d.lines {
+		return nil, ErrFallback
+	}
+	if d.start == nil {
+		d.start = d.build([]int{0}, true)
+	}
+	s, t := d.start, &thrash{}
+	found := map[int]bool{}
+	for pos := 0; ; {
+		r, w := in.Step(pos)
+		if w == 0 {
+			break
+		}
+		pos += w
+		for _, e := range s.exprs {
+			found[e] = true
+		}
+		if len(s.insts) == 0 {
+			return vm.SortedSet(found), nil
+		}
+		var err error
+		if s, err = d.next(s, r, t); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range s.exprsAtEnd {
+		found[e] = true
+	}
+	return vm.SortedSet(found), nil
+}
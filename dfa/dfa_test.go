@@ -0,0 +1,120 @@
+package dfa_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/susji/mre/compile"
+	"github.com/susji/mre/dfa"
+	"github.com/susji/mre/input"
+	"github.com/susji/mre/lex"
+	"github.com/susji/mre/match"
+	"github.com/susji/mre/vm"
+)
+
+func prog(t *testing.T, expr string) *vm.Prog {
+	root, err := compile.Compile(lex.Lex(expr))
+	if err != nil {
+		t.Fatal("compile failed: ", err)
+	}
+	p, err := vm.Compile(root)
+	if err != nil {
+		t.Fatal("vm compile failed: ", err)
+	}
+	return p
+}
+
+func TestMatch(t *testing.T) {
+	type entry struct {
+		expr    string
+		yes, no []string
+	}
+
+	table := []entry{
+		{"^a", []string{"a", "ab"}, []string{"", "ba"}},
+		{"b+c", []string{"abbc", "bc"}, []string{"abb", "c"}},
+		{"^a*ab$", []string{"ab", "aaab"}, []string{"aaa", "aaba"}},
+		{"x*", []string{"", "yyy"}, []string{}},
+		{"c$", []string{"c", "abc"}, []string{"ca", ""}},
+		{"^$", []string{""}, []string{"a"}},
+		{"^[^a-c]{2}$", []string{"xy", "öä"}, []string{"xa", "x", "xyz"}},
+		{"(ab|cd){2}e", []string{"abcde", "xxcdcde"}, []string{"abe", "cdabf"}},
+	}
+
+	for _, te := range table {
+		t.Run(te.expr, func(t *testing.T) {
+			d := dfa.New(prog(t, te.expr), dfa.DEFAULT_MAX_STATES)
+			for _, y := range te.yes {
+				// The same input twice to use the cached states.
+				for i := 0; i < 2; i++ {
+					matched, err := d.Match(input.String(y))
+					if err != nil || !matched {
+						t.Errorf("%q did not match: %v", y, err)
+					}
+				}
+			}
+			for _, n := range te.no {
+				for i := 0; i < 2; i++ {
+					matched, err := d.Match(input.String(n))
+					if err != nil || matched {
+						t.Errorf("%q matched: %v", n, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFlush(t *testing.T) {
+	p := prog(t, "a[ab]{3}c$")
+	in := input.String(strings.Repeat("abbab", 20) + "abbac")
+	// With enough states, the cache is flushed now and then, but the
+	// answer must stay the same.
+	d := dfa.New(p, 8)
+	matched, err := d.Match(in)
+	if err != nil || !matched {
+		t.Errorf("should match: %v", err)
+	}
+	// Without room for any states, we end up thrashing.
+	d = dfa.New(p, 1)
+	if _, err := d.Match(in); err != dfa.ErrFallback {
+		t.Errorf("wanted fallback, got %v", err)
+	}
+}
+
+func TestMatchSet(t *testing.T) {
+	exprs := []string{"^a", "b+c", "c$", "^$", "x"}
+	roots := []*match.Root{}
+	for _, expr := range exprs {
+		root, err := compile.Compile(lex.Lex(expr))
+		if err != nil {
+			t.Fatal("compile failed: ", err)
+		}
+		roots = append(roots, root)
+	}
+	p, err := vm.CompileSet(roots)
+	if err != nil {
+		t.Fatal("vm compile failed: ", err)
+	}
+	table := map[string][]int{
+		"":     {3},
+		"abbc": {0, 1, 2},
+		"ca":   nil,
+		"xbcx": {1, 4},
+	}
+	for _, maxStates := range []int{dfa.DEFAULT_MAX_STATES, 4} {
+		d := dfa.New(p, maxStates)
+		for test, exp := range table {
+			got, err := d.MatchSet(input.String(test))
+			if err != nil || !reflect.DeepEqual(got, exp) {
+				t.Errorf("%q with %d states: wanted %v, got %v (%v)",
+					test, maxStates, exp, got, err)
+			}
+			// The Pike VM must agree.
+			if got := p.MatchSet(input.String(test)); !reflect.DeepEqual(got, exp) {
+				t.Errorf("%q with Pike VM: wanted %v, got %v", test, exp, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mre
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/susji/mre/compile"
+)
+
+// SyntaxError describes a problem in an expression given to Compile.
+type SyntaxError struct {
+	Code compile.ErrorCode
+	// Expr is the whole expression.
+	Expr string
+	// Token is the text of the offending token. It is empty if the
+	// expression ended too early.
+	Token string
+	// The problem spans columns from Column up to but not including
+	// EndColumn. Columns are counted in runes and begin from one. Offset
+	// and EndOffset are the same span as byte offsets into Expr.
+	Column, EndColumn int
+	Offset, EndOffset int
+}
+
+func newSyntaxError(expr string, err *compile.Error) *SyntaxError {
+	e := &SyntaxError{
+		Code:      err.Code,
+		Expr:      expr,
+		Column:    int(err.Column),
+		EndColumn: int(err.End),
+	}
+	e.Offset = columnOffset(expr, e.Column)
+	e.EndOffset = columnOffset(expr, e.EndColumn)
+	if err.Tok != nil {
+		col := int(err.Tok.Column())
+		e.Token = expr[columnOffset(expr, col):columnOffset(
+			expr, col+int(err.Tok.Width()))]
+	}
+	return e
+}
+
+// columnOffset returns the byte offset of column col in expr. Columns past
+// the end of expr are at its end.
+func columnOffset(expr string, col int) int {
+	off := 0
+	for i := 1; i < col && off < len(expr); i++ {
+		_, w := utf8.DecodeRuneInString(expr[off:])
+		off += w
+	}
+	return off
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at column %d", e.Code, e.Column)
+}
+
+// Caret renders the expression and, below it, a line which marks the problem
+// with `^~~~'.
+func (e *SyntaxError) Caret() string {
+	b := &strings.Builder{}
+	b.WriteString(e.Expr)
+	b.WriteRune('\n')
+	// Tabs are kept as they are so that the marker lines up.
+	for _, r := range e.Expr[:e.Offset] {
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	b.WriteRune('^')
+	for i := e.Column + 1; i < e.EndColumn; i++ {
+		b.WriteRune('~')
+	}
+	b.WriteRune('\n')
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package mre_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/susji/mre"
+	"github.com/susji/mre/compile"
+)
+
+func TestSyntaxError(t *testing.T) {
+	type entry struct {
+		expr           string
+		code           compile.ErrorCode
+		column, end    int
+		offset, endoff int
+		token, caret   string
+	}
+
+	table := []entry{
+		{"", compile.ERR_EMPTY, 1, 2, 0, 0, "", "\n^\n"},
+		{"a)", compile.ERR_UNBALANCED_RPAREN, 2, 3, 1, 2, ")", "a)\n ^\n"},
+		{"(a", compile.ERR_MISSING_RPAREN, 1, 3, 0, 2, "", "(a\n^~\n"},
+		{"ä[z-a]", compile.ERR_SET_RANGE_NOT_MONOTONIC, 3, 6, 3, 6, "a",
+			"ä[z-a]\n  ^~~\n"},
+		{"x[ab", compile.ERR_MISSING_RBRACK, 2, 5, 1, 4, "", "x[ab\n ^~~\n"},
+		{"[a-", compile.ERR_SET_RANGE_MISSING_END, 2, 4, 1, 3, "",
+			"[a-\n ^~\n"},
+		{"a{2x}", compile.ERR_INVALID_REPEAT, 2, 5, 1, 4, "x",
+			"a{2x}\n ^~~\n"},
+		{"a{}", compile.ERR_INVALID_REPEAT, 2, 4, 1, 3, "}", "a{}\n ^~\n"},
+		{"a{1001}", compile.ERR_INVALID_REPEAT, 2, 8, 1, 7, "}",
+			"a{1001}\n ^~~~~~\n"},
+		{"a{2,1001}b", compile.ERR_INVALID_REPEAT, 2, 10, 1, 9, "}",
+			"a{2,1001}b\n ^~~~~~~~\n"},
+		{"a{3,1}", compile.ERR_INVALID_REPEAT, 2, 7, 1, 6, "}",
+			"a{3,1}\n ^~~~~\n"},
+		{"a{99999999999999999999}", compile.ERR_INVALID_REPEAT, 2, 24, 1, 23,
+			"}", "a{99999999999999999999}\n ^~~~~~~~~~~~~~~~~~~~~~\n"},
+		{"a{1,99999999999999999999}", compile.ERR_INVALID_REPEAT, 2, 26, 1,
+			25, "}", "a{1,99999999999999999999}\n ^~~~~~~~~~~~~~~~~~~~~~~~\n"},
+		{"a{2,3", compile.ERR_UNTERMINATED_REPEAT, 2, 6, 1, 5, "",
+			"a{2,3\n ^~~~\n"},
+		{"a$b", compile.ERR_TRAILING_DOLLAR, 3, 4, 2, 3, "b", "a$b\n  ^\n"},
+		{"\tb**", compile.ERR_UNEXPECTED_TOKEN, 4, 5, 3, 4, "*",
+			"\tb**\n\t  ^\n"},
+		{`[\d-z]`, compile.ERR_SET_RANGE_CLASS, 2, 6, 1, 5, "z",
+			"[\\d-z]\n ^~~~\n"},
+		{`a\x4`, compile.ERR_INVALID_ESCAPE, 2, 5, 1, 4, `\x4`,
+			"a\\x4\n ^~~\n"},
+		{`(\x{110000}`, compile.ERR_INVALID_ESCAPE, 2, 12, 1, 11,
+			`\x{110000}`, "(\\x{110000}\n ^~~~~~~~~~\n"},
+		{`ab\`, compile.ERR_INVALID_ESCAPE, 3, 4, 2, 3, `\`, "ab\\\n  ^\n"},
+		{`\berror\b`, compile.ERR_INVALID_ESCAPE, 1, 3, 0, 2, `\b`,
+			"\\berror\\b\n^~\n"},
+		{`x[\q]`, compile.ERR_INVALID_ESCAPE, 3, 5, 2, 4, `\q`,
+			"x[\\q]\n  ^~\n"},
+		{`[[:foo:]]`, compile.ERR_INVALID_POSIX_CLASS, 2, 9, 1, 8, "]",
+			"[[:foo:]]\n ^~~~~~~\n"},
+		{`[[:alpha]`, compile.ERR_INVALID_POSIX_CLASS, 2, 10, 1, 9, "]",
+			"[[:alpha]\n ^~~~~~~~\n"},
+		{`[[:digit:]-z]`, compile.ERR_SET_RANGE_CLASS, 2, 13, 1, 12, "z",
+			"[[:digit:]-z]\n ^~~~~~~~~~~\n"},
+		{`x\p{Foo}`, compile.ERR_UNKNOWN_PROPERTY, 2, 9, 1, 8, `\p{Foo}`,
+			"x\\p{Foo}\n ^~~~~~~\n"},
+		{`[\p{Bar}]`, compile.ERR_UNKNOWN_PROPERTY, 2, 9, 1, 8, `\p{Bar}`,
+			"[\\p{Bar}]\n ^~~~~~~\n"},
+		{`a\p{L`, compile.ERR_INVALID_ESCAPE, 2, 6, 1, 5, `\p{L`,
+			"a\\p{L\n ^~~~\n"},
+		{`a(?x)`, compile.ERR_INVALID_FLAGS, 2, 5, 1, 4, "x",
+			"a(?x)\n ^~~\n"},
+		{`(?)`, compile.ERR_INVALID_FLAGS, 1, 4, 0, 3, ")", "(?)\n^~~\n"},
+		{`(?i`, compile.ERR_INVALID_FLAGS, 1, 4, 0, 3, "", "(?i\n^~~\n"},
+		{`(?i-)`, compile.ERR_INVALID_FLAGS, 1, 6, 0, 5, ")",
+			"(?i-)\n^~~~~\n"},
+		{`(?-:a)`, compile.ERR_INVALID_FLAGS, 1, 5, 0, 4, ":",
+			"(?-:a)\n^~~~\n"},
+		{`(?P<a>x)(?P<a>y)`, compile.ERR_DUPLICATE_GROUP_NAME, 9, 15, 8, 14,
+			">", "(?P<a>x)(?P<a>y)\n        ^~~~~~\n"},
+		{`(?<>a)`, compile.ERR_INVALID_GROUP_NAME, 1, 5, 0, 4, ">",
+			"(?<>a)\n^~~~\n"},
+		{`(?P<a-b>x)`, compile.ERR_INVALID_GROUP_NAME, 1, 7, 0, 6, "-",
+			"(?P<a-b>x)\n^~~~~~\n"},
+		{`(?P=a)`, compile.ERR_INVALID_GROUP_NAME, 1, 5, 0, 4, "=",
+			"(?P=a)\n^~~~\n"},
+		{`(?<ab`, compile.ERR_INVALID_GROUP_NAME, 1, 6, 0, 5, "",
+			"(?<ab\n^~~~~\n"},
+		{`(?m-i-s)`, compile.ERR_INVALID_FLAGS, 1, 7, 0, 6, "-",
+			"(?m-i-s)\n^~~~~~\n"},
+		{`\.\.)`, compile.ERR_UNBALANCED_RPAREN, 5, 6, 4, 5, ")",
+			"\\.\\.)\n    ^\n"},
+	}
+
+	for _, te := range table {
+		t.Run(te.expr, func(t *testing.T) {
+			_, err := mre.Compile(te.expr)
+			var serr *mre.SyntaxError
+			if !errors.As(err, &serr) {
+				t.Fatalf("wanted a syntax error, got %v", err)
+			}
+			if serr.Code != te.code {
+				t.Errorf("wanted code %q, got %q", te.code, serr.Code)
+			}
+			if serr.Expr != te.expr {
+				t.Errorf("wanted expression %q, got %q", te.expr, serr.Expr)
+			}
+			if serr.Column != te.column || serr.EndColumn != te.end {
+				t.Errorf("wanted columns %d-%d, got %d-%d",
+					te.column, te.end, serr.Column, serr.EndColumn)
+			}
+			if serr.Offset != te.offset || serr.EndOffset != te.endoff {
+				t.Errorf("wanted offsets %d-%d, got %d-%d",
+					te.offset, te.endoff, serr.Offset, serr.EndOffset)
+			}
+			if serr.Token != te.token {
+				t.Errorf("wanted token %q, got %q", te.token, serr.Token)
+			}
+			if c := serr.Caret(); c != te.caret {
+				t.Errorf("wanted caret\n%s\ngot\n%s", te.caret, c)
+			}
+		})
+	}
+}
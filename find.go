@@ -0,0 +1,228 @@
+package mre
+
+import "github.com/susji/mre/input"
+
+// The functions below follow the contracts of their namesakes in Go's
+// `regexp'. Positions are byte offsets into the input.
+
+// all calls deliver with the capture positions of at most n successive
+// non-overlapping matches in in. If n is negative, there is no limit.
+func (m *MRE) all(in input.Input, n int, deliver func([]int)) {
+	for pos, i, prevEnd := 0, 0, -1; (n < 0 || i < n) && pos <= in.Len(); {
+		slots := m.find(in, pos)
+		if slots == nil {
+			break
+		}
+		accept := true
+		if slots[1] == pos {
+			// An empty match right after the previous match is not
+			// accepted. Either way, we move on by one rune.
+			if slots[0] == prevEnd {
+				accept = false
+			}
+			if _, w := in.Step(pos); w > 0 {
+				pos += w
+			} else {
+				pos++
+			}
+		} else {
+			pos = slots[1]
+		}
+		prevEnd = slots[1]
+		if accept {
+			deliver(slots)
+			i++
+		}
+	}
+}
+
+func submatches(s string, slots []int) []string {
+	ret := make([]string, len(slots)/2)
+	for i := range ret {
+		if slots[i*2] >= 0 {
+			ret[i] = s[slots[i*2]:slots[i*2+1]]
+		}
+	}
+	return ret
+}
+
+// FindString returns the text of the leftmost match in s. If there is no
+// match, the result is empty.
+func (m *MRE) FindString(s string) string {
+	loc := m.FindStringIndex(s)
+	if loc == nil {
+		return ""
+	}
+	return s[loc[0]:loc[1]]
+}
+
+// FindStringIndex returns the start and end of the leftmost match in s, or
+// nil if there is no match.
+func (m *MRE) FindStringIndex(s string) []int {
+	loc := m.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil
+	}
+	return loc[:2]
+}
+
+// FindStringSubmatch returns the text of the leftmost match in s followed by
+// the text of each subexpression. Subexpressions which did not take part in
+// the match are empty. If there is no match, nil is returned.
+func (m *MRE) FindStringSubmatch(s string) []string {
+	loc := m.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil
+	}
+	return submatches(s, loc)
+}
+
+// FindStringSubmatchMap returns the text of each named subexpression of the
+// leftmost match in s by name. Subexpressions which did not take part in the
+// match are empty. If there is no match, nil is returned.
+func (m *MRE) FindStringSubmatchMap(s string) map[string]string {
+	loc := m.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil
+	}
+	ret := map[string]string{}
+	for i, text := range submatches(s, loc) {
+		if name := m.names[i]; name != "" {
+			ret[name] = text
+		}
+	}
+	return ret
+}
+
+// FindStringSubmatchIndex returns index pairs for the leftmost match in s and
+// each of its subexpressions. Subexpressions which did not take part in the
+// match have -1 as their indices. If there is no match, nil is returned.
+func (m *MRE) FindStringSubmatchIndex(s string) []int {
+	var ret []int
+	m.all(input.String(s), 1, func(slots []int) {
+		ret = slots
+	})
+	return ret
+}
+
+// FindAllString returns the text of at most n successive matches in s, or all
+// of them if n is negative. If there is no match, nil is returned.
+func (m *MRE) FindAllString(s string, n int) []string {
+	var ret []string
+	m.all(input.String(s), n, func(slots []int) {
+		ret = append(ret, s[slots[0]:slots[1]])
+	})
+	return ret
+}
+
+// FindAllStringIndex is the 'All' version of FindStringIndex.
+func (m *MRE) FindAllStringIndex(s string, n int) [][]int {
+	var ret [][]int
+	m.all(input.String(s), n, func(slots []int) {
+		ret = append(ret, slots[:2])
+	})
+	return ret
+}
+
+// FindAllStringSubmatch is the 'All' version of FindStringSubmatch.
+func (m *MRE) FindAllStringSubmatch(s string, n int) [][]string {
+	var ret [][]string
+	m.all(input.String(s), n, func(slots []int) {
+		ret = append(ret, submatches(s, slots))
+	})
+	return ret
+}
+
+// FindAllStringSubmatchIndex is the 'All' version of FindStringSubmatchIndex.
+func (m *MRE) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	var ret [][]int
+	m.all(input.String(s), n, func(slots []int) {
+		ret = append(ret, slots)
+	})
+	return ret
+}
+
+func subslices(b []byte, slots []int) [][]byte {
+	ret := make([][]byte, len(slots)/2)
+	for i := range ret {
+		if slots[i*2] >= 0 {
+			ret[i] = b[slots[i*2]:slots[i*2+1]:slots[i*2+1]]
+		}
+	}
+	return ret
+}
+
+// Find returns the leftmost match in b, or nil if there is no match.
+func (m *MRE) Find(b []byte) []byte {
+	loc := m.FindIndex(b)
+	if loc == nil {
+		return nil
+	}
+	return b[loc[0]:loc[1]:loc[1]]
+}
+
+// FindIndex returns the start and end of the leftmost match in b, or nil if
+// there is no match.
+func (m *MRE) FindIndex(b []byte) []int {
+	loc := m.FindSubmatchIndex(b)
+	if loc == nil {
+		return nil
+	}
+	return loc[:2]
+}
+
+// FindSubmatch returns the leftmost match in b followed by the matches of
+// each subexpression. Subexpressions which did not take part in the match are
+// nil. If there is no match, nil is returned.
+func (m *MRE) FindSubmatch(b []byte) [][]byte {
+	loc := m.FindSubmatchIndex(b)
+	if loc == nil {
+		return nil
+	}
+	return subslices(b, loc)
+}
+
+// FindSubmatchIndex is like FindStringSubmatchIndex, but for a byte slice.
+func (m *MRE) FindSubmatchIndex(b []byte) []int {
+	var ret []int
+	m.all(input.Bytes(b), 1, func(slots []int) {
+		ret = slots
+	})
+	return ret
+}
+
+// FindAll is the 'All' version of Find.
+func (m *MRE) FindAll(b []byte, n int) [][]byte {
+	var ret [][]byte
+	m.all(input.Bytes(b), n, func(slots []int) {
+		ret = append(ret, b[slots[0]:slots[1]:slots[1]])
+	})
+	return ret
+}
+
+// FindAllIndex is the 'All' version of FindIndex.
+func (m *MRE) FindAllIndex(b []byte, n int) [][]int {
+	var ret [][]int
+	m.all(input.Bytes(b), n, func(slots []int) {
+		ret = append(ret, slots[:2])
+	})
+	return ret
+}
+
+// FindAllSubmatch is the 'All' version of FindSubmatch.
+func (m *MRE) FindAllSubmatch(b []byte, n int) [][][]byte {
+	var ret [][][]byte
+	m.all(input.Bytes(b), n, func(slots []int) {
+		ret = append(ret, subslices(b, slots))
+	})
+	return ret
+}
+
+// FindAllSubmatchIndex is the 'All' version of FindSubmatchIndex.
+func (m *MRE) FindAllSubmatchIndex(b []byte, n int) [][]int {
+	var ret [][]int
+	m.all(input.Bytes(b), n, func(slots []int) {
+		ret = append(ret, slots)
+	})
+	return ret
+}
@@ -0,0 +1,268 @@
+package mre_test
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/susji/mre"
+)
+
+// We compare against Go's `regexp' with expressions which mean the same for
+// both.
+var findTable = []struct {
+	expr, test string
+}{
+	{"a", "banana"},
+	{"an", "banana"},
+	{"(a)(n)?", "banana"},
+	{"x*", "banana"},
+	{"a*", "baaanaa"},
+	{"^b", "bb"},
+	{"b$", "bb"},
+	{"^$", ""},
+	{"(a|ab)(c|bcd)(d*)", "abcd"},
+	{"([0-9]+)-([a-z]*)", "12-ab 3- 45-c"},
+	{"((a)|b)+", "abab"},
+	{"[^ ]+", "hello wörld  again"},
+	{"ö+", "äöö öäö"},
+	{"(x)?y", "yxy"},
+	{"a{2,3}", "aaaaaaa"},
+	{"abc|abd|ae", "xabdaeabc"},
+	{"ab|abc|a", "abcab"},
+	{"(ab|abc)(c?)", "abcc"},
+	{"x(a|b|[c-e])+", "xaecbxd"},
+	{"[^ab-dc]+", "aexbyd"},
+	{"foo[0-9]+", "fo foo foo12 xfoo3"},
+	{"(foo|bar)baz", "foobar barbaz foobaz"},
+	{"x(ab)+y", "xaby xababy xy"},
+	{"(error|warning|fatal|panic)=([0-9]+)", "ok=1 warning=2 fatal=x panic=3"},
+	{"abcd|bc", "xabcd"},
+	{`\d+`, "a12 b345"},
+	{`\w+@\w+`, "mail foo_1@bar x@"},
+	{`\s+`, "a \t\nb\v"},
+	{`[\d\s]+`, "a1 2b"},
+	{`[^\d\s]+`, "a1 2bc"},
+	{`\D\W\S`, "1a!x y"},
+	{`[\D]+|x`, "12ab3"},
+	{`(\d|x)\w`, "x1 2_"},
+	{`a\tb|\n+`, "a\tb \n\n"},
+	{`[\r\n\f\v]+`, "a\r\n\v\fb"},
+	{`\x41\x{e4}+\x{263A}`, "Aää☺ Aä"},
+	{`[\x00-\x1f]+`, "a\x01\x1fb\x00"},
+	{`\0`, "a\x00b"},
+	{`[[:alpha:]]+`, "ab1 cD2"},
+	{`[[:^alpha:][:upper:]]+`, "ab1;C dE"},
+	{`[^[:space:][:punct:]]+`, "a.b, c!d\te"},
+	{`[x[:digit:]_]+`, "ax1_2 y"},
+	{`[[:xdigit:]]+|[[:cntrl:]]`, "0xfF g\x7f"},
+	{`[[a+*]+`, "x[a+b*"},
+	{`[a(|)]+`, "b(a|)c"},
+	{`\pL+`, "äbc 12 ωx"},
+	{`\p{Lu}\p{Ll}+`, "hello World ÄÖö"},
+	{`\p{Greek}+`, "abc αβγ def Ω"},
+	{`\P{N}+|\p{^Greek}`, "12ab3αβ"},
+	{`[\p{Lu}\d]+`, "aB1Äc"},
+	{`[^\p{L}\s]+`, "ab 12,3 cd!"},
+	{`\p{Any}`, "\xffa"},
+	{`\p{So}+`, "a\xff☺b"},
+	{`(?i)error`, "ERROR Error eRRoR err"},
+	{`(?i)straße`, "STRASSE STRAẞE straße"},
+	{`(?i)k+`, "xKk\u212Ay"},
+	{`(?i)ǅ+`, "ǄǅǆDž"},
+	{`a(?i)b|c`, "aB C c ab Ab"},
+	{`x((?i)a)b`, "xAb xAB xab"},
+	{`(?i)[a-c]+`, "xAbCd"},
+	{`(?i)[^a]`, "AaB"},
+	{`(?i)\w+`, "ſK! abc"},
+	{`(?i)[\W]`, "k\u212As!"},
+	{`(?i)\W`, "k\u212As!"},
+	{`(?i)\p{Lu}+`, "1abcD"},
+	{`(?i)\P{Ll}`, "abC1"},
+	{`(?i)[[:upper:]]+`, "12abCD"},
+	{`(?i)(?i)x`, "X"},
+	{`a.b`, "a\nb axb"},
+	{`(?s)a.b`, "a\nb axb"},
+	{`[^a]+`, "b\nc"},
+	{`(?m)^\w+$`, "ab\ncd\n"},
+	{`(?m)^`, "\na\n\n"},
+	{`(?m)$`, "\na\n\n"},
+	{`(?m)^$`, "a\n\nb\n"},
+	{`a(?m:$)|^b`, "a\nbab"},
+	{`(^a|b)+`, "aab ab"},
+	{`x(?m)\n^y`, "x\ny"},
+	{`(?U)a+`, "aaa"},
+	{`(?U)(a*)(a*)`, "aaa"},
+	{`(?U)a{1,3}b?`, "aaab"},
+	{`(?U:a+)a+`, "aaaa"},
+	{`(?i:ab)c`, "ABc ABC"},
+	{`(?i)a(?-i)b`, "Ab AB ab"},
+	{`(?i)a(?-i:b)c`, "AbC ABC"},
+	{`(?is)A.b`, "a\nB"},
+	{`(?ms)^.+$`, "ab\ncd"},
+	{`(?m-s)^.+$`, "ab\ncd"},
+	{`(?:ab)+(c)`, "ababc abc c"},
+	{`(?:a|b(?:c|d))(e)?`, "bde ace"},
+	{`x(?:)y`, "xy"},
+	{`((?:a)(b))(?:c)(d)`, "abcd"},
+	{`(?:(?i)a)a`, "Aa AA"},
+	{`(?P<year>\d+)-(?<month>\d+)`, "on 2024-05 and 1999-12"},
+	{`(?P<a>x(?P<b>y)?)z`, "xz xyz"},
+	{`(?<first_1>a)|(?P<B>b)`, "ba"},
+	{`"(.*?)"`, `say "a" and "b c"`},
+	{`a+?`, "aaa"},
+	{`a*?b`, "aaab b"},
+	{`(a??)(a)`, "aa"},
+	{`(a*?)(a*)`, "aaa"},
+	{`a{2,4}?`, "aaaaa"},
+	{`(a{2,}?)(a?)`, "aaaa"},
+	{`a{2}?b`, "aab"},
+	{`(a+?)(b*?)c`, "aabbc"},
+	{`(a|ab)*?c`, "ababc"},
+	{`<(.+?)>`, "<a><b>"},
+	{`(?U)a+?`, "aaa"},
+	{`(?U)(a??)(a)`, "aa"},
+	{`(\w+?)(\d*)$`, "abc123"},
+	// An empty iteration of an unbounded loop is accepted only as the first
+	// one, and it ends the loop.
+	{`(a??)*`, "ab"},
+	{`(a*)*b`, "aab b"},
+	{`(a*)+b`, "aab b"},
+	{`(a*){2,}b`, "aab b"},
+	{`(a*){2,3}b`, "aab b"},
+	{`(|a)*b`, "ab"},
+	{`(|a)+b`, "ab"},
+	{`(|a){2}b`, "ab"},
+	{`(|a){0,2}b`, "ab"},
+	{`(|a){2,}b`, "aab"},
+	{`(a|)*?b`, "aab"},
+	{`(a??)+?b`, "aab"},
+	{`((a)|b*)*c`, "abbc"},
+	{`(a*)*`, "aa"},
+	{`(a?)*?$`, "aa"},
+	// A loop passing the split of an inner loop again at the same position
+	// stops there.
+	{`(a*?)*c`, "aac"},
+	{`(?:b*(a)*?)+`, "abba"},
+	{`(a+|[ab]*?)+`, "abba"},
+	{`((a)*|b[ab]){2}`, "abba"},
+	// An empty branch matches the empty string.
+	{`a|`, "bab"},
+	{`|a`, "bab"},
+	{`a|b|`, "cab"},
+	// A '-' before the closing ']' is literal.
+	{`[\w-]+`, "foo-bar baz"},
+	{`[\d.-]+`, "v1.2-3 x"},
+	{`[a-]+`, "b-a-c"},
+	{"a\uFFFDb", "a\xffb a\uFFFDb"},
+	{"\uFFFD+", "a\xff\xfeb"},
+	// Invalid UTF-8 is read one byte at a time.
+	{".", "a\xffb"},
+	{"[^a]+", "\xff\xfeab\xc3"},
+	{"x*", "\xe2\x82"},
+	{`\D+`, "a\xffb1"},
+}
+
+func TestFind(t *testing.T) {
+	for _, te := range findTable {
+		std := regexp.MustCompile(te.expr)
+		for en, e := range engines {
+			t.Run(en+"_"+te.expr+"_"+te.test, func(t *testing.T) {
+				m, err := mre.CompileWith(
+					te.expr, &mre.CompileOptions{Engine: e})
+				if err != nil {
+					t.Fatal("compile failed: ", err)
+				}
+				check := func(what string, got, want interface{}) {
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("%s: wanted %#v, got %#v", what, want, got)
+					}
+				}
+				check("FindString",
+					m.FindString(te.test),
+					std.FindString(te.test))
+				check("FindStringIndex",
+					m.FindStringIndex(te.test),
+					std.FindStringIndex(te.test))
+				check("FindStringSubmatch",
+					m.FindStringSubmatch(te.test),
+					std.FindStringSubmatch(te.test))
+				check("FindStringSubmatchIndex",
+					m.FindStringSubmatchIndex(te.test),
+					std.FindStringSubmatchIndex(te.test))
+				b := []byte(te.test)
+				check("MatchBytes",
+					m.MatchBytes(b),
+					std.Match(b))
+				check("Find",
+					m.Find(b),
+					std.Find(b))
+				check("FindIndex",
+					m.FindIndex(b),
+					std.FindIndex(b))
+				check("FindSubmatch",
+					m.FindSubmatch(b),
+					std.FindSubmatch(b))
+				check("FindSubmatchIndex",
+					m.FindSubmatchIndex(b),
+					std.FindSubmatchIndex(b))
+				for _, n := range []int{-1, 0, 1, 2} {
+					check("FindAllString",
+						m.FindAllString(te.test, n),
+						std.FindAllString(te.test, n))
+					check("FindAllStringIndex",
+						m.FindAllStringIndex(te.test, n),
+						std.FindAllStringIndex(te.test, n))
+					check("FindAllStringSubmatch",
+						m.FindAllStringSubmatch(te.test, n),
+						std.FindAllStringSubmatch(te.test, n))
+					check("FindAllStringSubmatchIndex",
+						m.FindAllStringSubmatchIndex(te.test, n),
+						std.FindAllStringSubmatchIndex(te.test, n))
+					check("FindAll",
+						m.FindAll(b, n),
+						std.FindAll(b, n))
+					check("FindAllIndex",
+						m.FindAllIndex(b, n),
+						std.FindAllIndex(b, n))
+					check("FindAllSubmatch",
+						m.FindAllSubmatch(b, n),
+						std.FindAllSubmatch(b, n))
+					check("FindAllSubmatchIndex",
+						m.FindAllSubmatchIndex(b, n),
+						std.FindAllSubmatchIndex(b, n))
+				}
+			})
+		}
+	}
+}
+
+func TestFindKeywords(t *testing.T) {
+	// Hundreds of keywords, many of which share a beginning.
+	words := []string{}
+	for i := 0; i < 500; i++ {
+		w := []byte{}
+		for n := i*7919 + 13; len(w) < 4; n /= 26 {
+			w = append(w, byte('a'+n%26))
+		}
+		words = append(words, string(w))
+	}
+	expr := "(" + strings.Join(words, "|") + ")[0-9]?"
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog ", 20) +
+		strings.Join(words[200:210], "1 ") + " " + words[499]
+	std := regexp.MustCompile(expr)
+	for en, e := range engines {
+		t.Run(en, func(t *testing.T) {
+			m, err := mre.CompileWith(expr, &mre.CompileOptions{Engine: e})
+			if err != nil {
+				t.Fatal("compile failed: ", err)
+			}
+			got := m.FindAllStringSubmatchIndex(text, -1)
+			want := std.FindAllStringSubmatchIndex(text, -1)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("wanted %v, got %v", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Package input lets the matchers decode UTF-8 text on the fly without
+// converting it into runes first. Positions are byte offsets into the text.
+//
+// Invalid UTF-8 is decoded one byte at a time: each byte which does not
+// belong to a valid encoding is read as utf8.RuneError with a width of one.
+package input
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// Input is text to be matched.
+type Input interface {
+	// Step returns the rune at byte offset pos and its width in bytes. If
+	// there is nothing left, the width is zero.
+	Step(pos int) (rune, int)
+	// Back returns the rune which ends at byte offset pos and its width in
+	// bytes. The runes are the same ones Step gives. If pos is zero, the
+	// width is zero.
+	Back(pos int) (rune, int)
+	// Len returns the length of the text in bytes.
+	Len() int
+	// Index returns the byte offset of the first occurrence of lit at or
+	// after byte offset from, or -1 if there is none.
+	Index(lit string, from int) int
+}
+
+type String string
+
+type Bytes []byte
+
+func (s String) Step(pos int) (rune, int) {
+	if pos >= len(s) {
+		return utf8.RuneError, 0
+	}
+	if c := s[pos]; c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	return utf8.DecodeRuneInString(string(s[pos:]))
+}
+
+func (s String) Back(pos int) (rune, int) {
+	if pos <= 0 {
+		return utf8.RuneError, 0
+	}
+	if c := s[pos-1]; c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	return utf8.DecodeLastRuneInString(string(s[:pos]))
+}
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func (s String) Index(lit string, from int) int {
+	if from > len(s) {
+		return -1
+	}
+	i := strings.Index(string(s[from:]), lit)
+	if i < 0 {
+		return -1
+	}
+	return from + i
+}
+
+func (b Bytes) Step(pos int) (rune, int) {
+	if pos >= len(b) {
+		return utf8.RuneError, 0
+	}
+	if c := b[pos]; c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	return utf8.DecodeRune(b[pos:])
+}
+
+func (b Bytes) Back(pos int) (rune, int) {
+	if pos <= 0 {
+		return utf8.RuneError, 0
+	}
+	if c := b[pos-1]; c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	return utf8.DecodeLastRune(b[:pos])
+}
+
+func (b Bytes) Len() int {
+	return len(b)
+}
+
+func (b Bytes) Index(lit string, from int) int {
+	if from > len(b) {
+		return -1
+	}
+	i := bytes.Index(b[from:], []byte(lit))
+	if i < 0 {
+		return -1
+	}
+	return from + i
+}
@@ -0,0 +1,125 @@
+package input_test
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/susji/mre/input"
+)
+
+func TestStep(t *testing.T) {
+	type step struct {
+		r rune
+		w int
+	}
+	type entry struct {
+		test string
+		exp  []step
+	}
+
+	table := []entry{
+		{"", []step{}},
+		{"aö€", []step{{'a', 1}, {'ö', 2}, {'€', 3}}},
+		{"a\xffb", []step{{'a', 1}, {utf8.RuneError, 1}, {'b', 1}}},
+		// A truncated encoding is invalid byte by byte.
+		{"\xe2\x82", []step{{utf8.RuneError, 1}, {utf8.RuneError, 1}}},
+	}
+
+	for _, te := range table {
+		for _, in := range []input.Input{
+			input.String(te.test), input.Bytes(te.test)} {
+			t.Run(te.test, func(t *testing.T) {
+				if in.Len() != len(te.test) {
+					t.Errorf("wanted length %d, got %d",
+						len(te.test), in.Len())
+				}
+				pos := 0
+				for i, exp := range te.exp {
+					r, w := in.Step(pos)
+					if r != exp.r || w != exp.w {
+						t.Errorf("%d: wanted %q/%d, got %q/%d",
+							i, exp.r, exp.w, r, w)
+					}
+					pos += w
+				}
+				if _, w := in.Step(pos); w != 0 {
+					t.Errorf("wanted zero width at the end, got %d", w)
+				}
+			})
+		}
+	}
+}
+
+func TestBack(t *testing.T) {
+	// Going back must meet the same runes as stepping forward, also when
+	// the encoding is invalid.
+	table := []string{
+		"",
+		"aö€😀",
+		"a\xffb",
+		"\xe2\x82",
+		"\xe2\x82\xac\xac",
+		"\xc3\xa9\xa9",
+		"\xe0\x80\x80",
+		"\xc3\xe2\x82\xac",
+		"\xf0\x9f\x98",
+	}
+	type step struct {
+		r        rune
+		pos, end int
+	}
+	for _, test := range table {
+		for _, in := range []input.Input{
+			input.String(test), input.Bytes(test)} {
+			t.Run(test, func(t *testing.T) {
+				steps := []step{}
+				for pos := 0; ; {
+					r, w := in.Step(pos)
+					if w == 0 {
+						break
+					}
+					steps = append(steps, step{r, pos, pos + w})
+					pos += w
+				}
+				pos := in.Len()
+				for i := len(steps) - 1; i >= 0; i-- {
+					r, w := in.Back(pos)
+					if got := (step{r, pos - w, pos}); got != steps[i] {
+						t.Errorf("wanted %v, got %v", steps[i], got)
+					}
+					pos -= w
+				}
+				if _, w := in.Back(pos); pos != 0 || w != 0 {
+					t.Errorf("wanted zero width at 0, got %d at %d", w, pos)
+				}
+			})
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	type entry struct {
+		text, lit string
+		from, exp int
+	}
+
+	table := []entry{
+		{"abcabc", "bc", 0, 1},
+		{"abcabc", "bc", 2, 4},
+		{"abcabc", "bc", 5, -1},
+		{"abc", "", 3, 3},
+		{"abc", "", 4, -1},
+		{"öäö", "ö", 1, 4},
+		{"a\xffö", "ö", 0, 2},
+	}
+
+	for _, te := range table {
+		for _, in := range []input.Input{
+			input.String(te.text), input.Bytes(te.text)} {
+			if got := in.Index(te.lit, te.from); got != te.exp {
+				t.Errorf("%T %q: wanted %q from %d at %d, got %d",
+					in, te.text, te.lit, te.from, te.exp, got)
+			}
+		}
+	}
+}
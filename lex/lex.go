@@ -1,6 +1,125 @@
 package lex
 
-import "github.com/susji/mre/token"
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/susji/mre/token"
+)
+
+// hex returns the value of hexadecimal digits. If some rune is not one or
+// the value does not fit a rune, ok is false.
+func hex(digits []rune) (ret rune, ok bool) {
+	if len(digits) == 0 {
+		return 0, false
+	}
+	for _, d := range digits {
+		var v rune
+		switch {
+		case d >= '0' && d <= '9':
+			v = d - '0'
+		case d >= 'a' && d <= 'f':
+			v = d - 'a' + 10
+		case d >= 'A' && d <= 'F':
+			v = d - 'A' + 10
+		default:
+			return 0, false
+		}
+		if ret = ret*16 + v; ret > unicode.MaxRune {
+			return 0, false
+		}
+	}
+	return ret, true
+}
+
+// fixed returns the value of the n hexadecimal digits beginning rest and how
+// many runes were looked at.
+func fixed(rest []rune, n int) (token.TokenKind, rune, int) {
+	if len(rest) < n {
+		return token.TOK_INVALID, 0, len(rest)
+	}
+	r, ok := hex(rest[:n])
+	if !ok {
+		return token.TOK_INVALID, 0, n
+	}
+	return token.TOK_RUNE, r, n
+}
+
+// escape evaluates the escape sequence after a backslash. It returns the
+// kind and rune of the token and how many runes the sequence spans after the
+// backslash. Malformed sequences give TOK_INVALID.
+func escape(rest []rune) (token.TokenKind, rune, int) {
+	if len(rest) == 0 {
+		return token.TOK_INVALID, 0, 0
+	}
+	switch r := rest[0]; r {
+	case 'd', 'D', 'w', 'W', 's', 'S':
+		return token.TOK_CLASS, r, 1
+	case 'n':
+		return token.TOK_RUNE, '\n', 1
+	case 't':
+		return token.TOK_RUNE, '\t', 1
+	case 'r':
+		return token.TOK_RUNE, '\r', 1
+	case 'f':
+		return token.TOK_RUNE, '\f', 1
+	case 'v':
+		return token.TOK_RUNE, '\v', 1
+	case '0':
+		return token.TOK_RUNE, 0, 1
+	case 'u':
+		// \uHHHH
+		kind, r, n := fixed(rest[1:], 4)
+		return kind, r, n + 1
+	case 'x':
+		if len(rest) < 2 || rest[1] != '{' {
+			// \xHH
+			kind, r, n := fixed(rest[1:], 2)
+			return kind, r, n + 1
+		}
+		// \x{H..}
+		for i := 2; i < len(rest); i++ {
+			if rest[i] != '}' {
+				continue
+			}
+			r, ok := hex(rest[2:i])
+			if !ok {
+				return token.TOK_INVALID, 0, i + 1
+			}
+			return token.TOK_RUNE, r, i + 1
+		}
+		return token.TOK_INVALID, 0, len(rest)
+	default:
+		// Other escaped letters and digits are reserved, like with Go's
+		// regexp, so that \b, for example, does not quietly mean 'b'. The
+		// rest of the escaped runes stand for themselves.
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return token.TOK_INVALID, 0, 1
+		}
+		return token.TOK_RUNE, r, 1
+	}
+}
+
+// property reads the name of a Unicode class after \p or \P. The name is
+// either a single rune or enclosed in braces. It returns the kind of the
+// token, the name, and how many runes the name spans.
+func property(rest []rune) (token.TokenKind, string, int) {
+	if len(rest) == 0 {
+		return token.TOK_INVALID, "", 0
+	}
+	if rest[0] != '{' {
+		return token.TOK_PROPERTY, string(rest[0]), 1
+	}
+	for i := 1; i < len(rest); i++ {
+		if rest[i] == '}' {
+			if i == 1 {
+				return token.TOK_INVALID, "", i + 1
+			}
+			return token.TOK_PROPERTY, string(rest[1:i]), i + 1
+		}
+	}
+	return token.TOK_INVALID, "", len(rest)
+}
 
 func Lex(regexp string) *token.Tokens {
 	runes := []rune(regexp)
@@ -8,19 +127,23 @@ func Lex(regexp string) *token.Tokens {
 
 	col := uint(1)
 
-	escaped := false
-	for _, r := range runes {
-		if r == '\\' && !escaped {
-			escaped = true
-			col++
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '\\' && i+1 < len(runes) &&
+			(runes[i+1] == 'p' || runes[i+1] == 'P') {
+			kind, name, n := property(runes[i+2:])
+			// The token spans the backslash, the 'p' and the name.
+			toks.PushText(kind, col, uint(n+2), runes[i+1], name)
+			col += uint(n + 2)
+			i += n + 1
 			continue
-		} else if escaped {
-			/*
-			 * If we want to support escapes with special meanings, they should
-			 * be evaluated here. Otherwise it's a short-circuiting rune-add.
-			 */
-			toks.Push(token.TOK_RUNE, col, r)
-			escaped = false
+		}
+		if r == '\\' {
+			kind, ru, n := escape(runes[i+1:])
+			// The token begins from the backslash.
+			toks.PushWide(kind, col, uint(n+1), ru)
+			col += uint(n + 1)
+			i += n
 			continue
 		}
 		var tk token.TokenKind
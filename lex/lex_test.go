@@ -57,6 +57,17 @@ func TestLexBasic(t *testing.T) {
 				exp{token.TOK_RUNE, 'c'},
 			},
 		},
+		entry{
+			test: `\d\W[\s\a]`,
+			exp: []exp{
+				exp{token.TOK_CLASS, 'd'},
+				exp{token.TOK_CLASS, 'W'},
+				exp{token.TOK_LBRACK, '['},
+				exp{token.TOK_CLASS, 's'},
+				exp{token.TOK_RUNE, 'a'},
+				exp{token.TOK_RBRACK, ']'},
+			},
+		},
 	}
 
 	for _, te := range table {
@@ -72,7 +83,8 @@ func TestLexBasic(t *testing.T) {
 				tok := toks.Get()
 				if tok.Kind() != te.exp[i].kind {
 					t.Errorf("%d, kind mismatch, wanted '%c'", i, te.exp[i].ru)
-				} else if tok.Kind() == token.TOK_RUNE &&
+				} else if (tok.Kind() == token.TOK_RUNE ||
+					tok.Kind() == token.TOK_CLASS) &&
 					te.exp[i].ru != tok.Rune() {
 					t.Errorf("%d, wanted rune %c, got %c",
 						i, te.exp[i].ru, tok.Rune())
@@ -81,3 +93,112 @@ func TestLexBasic(t *testing.T) {
 		})
 	}
 }
+
+func TestLexColumns(t *testing.T) {
+	// An escaped rune spans its backslash, too.
+	toks := lex.Lex(`ä\.b`)
+	exp := []struct {
+		column, width uint
+	}{{1, 1}, {2, 2}, {4, 1}}
+	if toks.Count() != len(exp) {
+		t.Fatalf("wanted %d tokens, got %d", len(exp), toks.Count())
+	}
+	for i, e := range exp {
+		tok := toks.Get()
+		if tok.Column() != e.column || tok.Width() != e.width {
+			t.Errorf("%d: wanted column %d and width %d, got %d and %d",
+				i, e.column, e.width, tok.Column(), tok.Width())
+		}
+	}
+	if toks.End() != 5 {
+		t.Errorf("wanted end at 5, got %d", toks.End())
+	}
+}
+
+func TestLexEscapes(t *testing.T) {
+	type entry struct {
+		test string
+		exp  []rune
+	}
+
+	table := []entry{
+		{`\n\t\r\f\v\0`, []rune{'\n', '\t', '\r', '\f', '\v', 0}},
+		{`\x41\x{e4}\x{1F600}`, []rune{'A', 'ä', '😀'}},
+		{`\u00e4\u00C4x`, []rune{'ä', 'Ä', 'x'}},
+		{`\x31a`, []rune{'1', 'a'}},
+	}
+
+	for _, te := range table {
+		toks := lex.Lex(te.test)
+		if toks.Count() != len(te.exp) {
+			t.Errorf("%s: wanted %d tokens, got %d",
+				te.test, len(te.exp), toks.Count())
+			continue
+		}
+		for i, r := range te.exp {
+			tok := toks.Get()
+			if tok.Kind() != token.TOK_RUNE || tok.Rune() != r {
+				t.Errorf("%s: %d: wanted %q, got %s", te.test, i, r, tok)
+			}
+		}
+	}
+}
+
+func TestLexInvalidEscapes(t *testing.T) {
+	type entry struct {
+		test          string
+		column, width uint
+	}
+
+	table := []entry{
+		{`a\`, 2, 1},
+		{`a\x4`, 2, 3},
+		{`\xg1b`, 1, 4},
+		{`\x{}`, 1, 4},
+		{`\x{41`, 1, 5},
+		{`\x{110000}`, 1, 10},
+		{`\u12x4b`, 1, 6},
+		{`\u12`, 1, 4},
+		{`\berror\b`, 1, 2},
+		{`a\B`, 2, 2},
+		{`\A`, 1, 2},
+		{`ab\z`, 3, 2},
+		{`[\q]`, 2, 2},
+		{`\1`, 1, 2},
+	}
+
+	for _, te := range table {
+		tok := lex.Lex(te.test).First(token.TOK_INVALID)
+		if tok == nil {
+			t.Errorf("%s: no invalid token", te.test)
+			continue
+		}
+		if tok.Column() != te.column || tok.Width() != te.width {
+			t.Errorf("%s: wanted column %d and width %d, got %d and %d",
+				te.test, te.column, te.width, tok.Column(), tok.Width())
+		}
+	}
+}
+
+func TestLexProperties(t *testing.T) {
+	toks := lex.Lex(`\pL\P{Greek}\p{^Lu}x`)
+	exp := []struct {
+		ru            rune
+		text          string
+		column, width uint
+	}{{'p', "L", 1, 3}, {'P', "Greek", 4, 9}, {'p', "^Lu", 13, 7}}
+	for i, e := range exp {
+		tok := toks.Get()
+		if tok.Kind() != token.TOK_PROPERTY || tok.Rune() != e.ru ||
+			tok.Text() != e.text {
+			t.Errorf("%d: wanted \\%c{%s}, got %s", i, e.ru, e.text, tok)
+		}
+		if tok.Column() != e.column || tok.Width() != e.width {
+			t.Errorf("%d: wanted column %d and width %d, got %d and %d",
+				i, e.column, e.width, tok.Column(), tok.Width())
+		}
+	}
+	if tok := toks.Get(); tok.Kind() != token.TOK_RUNE || tok.Rune() != 'x' {
+		t.Errorf("wanted 'x', got %s", tok)
+	}
+}
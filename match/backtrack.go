@@ -0,0 +1,418 @@
+package match
+
+// The backtracker keeps all of its state on the heap, so that neither long
+// inputs nor long repetitions grow the Go stack. What is left to do after a
+// node has matched is a chain of continuation frames. The ways of matching
+// which have not been tried yet are choices on a stack, and the capture
+// positions overwritten since the oldest choice are kept on a trail, so that
+// they can be restored when a choice is taken.
+//
+// Like Go's regexp, the backtracker lets a way of matching die when it passes
+// the same split of the expression twice at the same position. This decides
+// what an iteration of a loop matching nothing does, and which iteration of
+// nested loops a capture reports. The splits passed at the current position
+// are marked on a stack, but only if the expression has a loop which may
+// match the empty string, as otherwise no split can be passed twice.
+
+// maxKeptChoices limits how much backtracking state a context keeps between
+// matches.
+const maxKeptChoices = 1 << 12
+
+type contKind uint8
+
+const (
+	// contSeq matches the members of the All n from member i on.
+	contSeq = contKind(iota)
+	// contAlt tries the alternatives of the AnyOf n from alternative i on.
+	contAlt
+	// contCapture ends capture i, which began at at.
+	contCapture
+	// contGreedy ends an iteration of a greedy repetition of n, which began
+	// with the bounds a and b left.
+	contGreedy
+	// contLazy begins an iteration of a lazy repetition of n with the
+	// bounds a and b left.
+	contLazy
+	// contLazyEnd ends an iteration of a lazy repetition like contGreedy.
+	contLazyEnd
+	// contGiveBack gives back one rune of a greedy repetition of the single
+	// rune matcher n, which has the bounds a and b left. It may give back
+	// runes down to at.
+	contGiveBack
+	// contAtomic drops the choices made after the first i.
+	contAtomic
+	// contEnd requires the end of input.
+	contEnd
+	// contScan tries the ScanTry n from the next possible position.
+	contScan
+)
+
+// cont is a continuation frame. Which fields are used depends on the kind.
+type cont struct {
+	kind contKind
+	n    Node
+	i    int
+	at   int
+	a, b int
+	next *cont
+}
+
+// choice is a way of matching not tried yet: k is resumed at at after
+// undoing the capture changes on the trail after the first trail and
+// dropping the marks after the first marks.
+type choice struct {
+	at    int
+	k     *cont
+	trail int
+	marks int
+}
+
+// mark tells that split id of n was passed at at on the way to k.
+type mark struct {
+	n  Node
+	id int
+	at int
+	k  *cont
+}
+
+// splitNode is the id of the split of an alternation or an optional node.
+// The splits of repetitions are told apart by the bounds they have left.
+const splitNode = -2
+
+// undo holds the positions capture i had before it was overwritten.
+type undo struct {
+	i, a, b int
+}
+
+// single is implemented by the matchers which match exactly one rune.
+type single interface {
+	Node
+	matchRune(r rune) bool
+}
+
+// run matches n from byte offset at of the context input. It returns where
+// the preferred match ends.
+func (ctx *Context) run(n Node, at int) (int, bool) {
+	ctx.k, ctx.choices = nil, ctx.choices[:0]
+	ctx.trail, ctx.marks = ctx.trail[:0], ctx.marks[:0]
+	defer ctx.release()
+	ok := n.exec(ctx, at, nil)
+	for {
+		if !ok {
+			if !ctx.backtrack() {
+				return -1, false
+			}
+		}
+		if ctx.k == nil {
+			return ctx.at, true
+		}
+		ok = ctx.k.resume(ctx, ctx.at)
+	}
+}
+
+// release drops the state of the last match, so that a context does not
+// keep much of it around.
+func (ctx *Context) release() {
+	ctx.k = nil
+	if cap(ctx.choices) > maxKeptChoices {
+		ctx.choices, ctx.trail = nil, nil
+	}
+	if cap(ctx.marks) > maxKeptChoices {
+		ctx.marks = nil
+	}
+}
+
+// resume arranges for k to continue from at.
+func (ctx *Context) resume(at int, k *cont) {
+	ctx.at, ctx.k = at, k
+}
+
+// push leaves a choice for resuming k at at.
+func (ctx *Context) push(at int, k *cont) {
+	ctx.choices = append(ctx.choices, choice{
+		at: at, k: k, trail: len(ctx.trail), marks: len(ctx.marks)})
+}
+
+// backtrack takes the latest choice. It returns false if there is none.
+func (ctx *Context) backtrack() bool {
+	if len(ctx.choices) == 0 {
+		return false
+	}
+	c := ctx.choices[len(ctx.choices)-1]
+	ctx.choices = ctx.choices[:len(ctx.choices)-1]
+	for len(ctx.trail) > c.trail {
+		u := ctx.trail[len(ctx.trail)-1]
+		ctx.trail = ctx.trail[:len(ctx.trail)-1]
+		ctx.slots[u.i*2], ctx.slots[u.i*2+1] = u.a, u.b
+	}
+	ctx.marks = ctx.marks[:c.marks]
+	ctx.resume(c.at, c.k)
+	return true
+}
+
+// visit marks the split id of n as passed at at on the way to k. It returns
+// false if it was passed there already.
+func (ctx *Context) visit(n Node, id, at int, k *cont) bool {
+	if !ctx.cycles {
+		return true
+	}
+	// The marks are in the order of position, so they are looked up only
+	// until an earlier position. Those after the latest choice are no
+	// longer needed once the position has changed.
+	l := len(ctx.marks)
+	for i := l - 1; i >= 0 && ctx.marks[i].at == at; i-- {
+		m := &ctx.marks[i]
+		if m.n == n && m.id == id && sameCont(m.k, k) {
+			return false
+		}
+	}
+	if l > 0 && ctx.marks[l-1].at != at {
+		keep := 0
+		if len(ctx.choices) > 0 {
+			keep = ctx.choices[len(ctx.choices)-1].marks
+		}
+		ctx.marks = ctx.marks[:keep]
+	}
+	ctx.marks = append(ctx.marks, mark{n: n, id: id, at: at, k: k})
+	return true
+}
+
+// sameCont tells if x and y continue at the same point of the expression.
+// The positions they carry do not matter.
+func sameCont(x, y *cont) bool {
+	for x != y {
+		if x == nil || y == nil || x.kind != y.kind || x.n != y.n {
+			return false
+		}
+		switch x.kind {
+		case contSeq, contCapture:
+			if x.i != y.i {
+				return false
+			}
+		case contGreedy, contLazy, contLazyEnd:
+			if iteration(x.a, x.b) != iteration(y.a, y.b) {
+				return false
+			}
+		}
+		x, y = x.next, y.next
+	}
+	return true
+}
+
+// iteration tells which copy of n an iteration of a repetition with the
+// bounds a and b left matches. As with Go's regexp, the iterations past the
+// minimum of an unbounded repetition share one copy, and the others have
+// their own.
+func iteration(a, b int) int {
+	if b != RANGE_UNBOUND {
+		return b
+	}
+	if a <= 1 {
+		return 1
+	}
+	return a
+}
+
+// loopSplit returns the id of the split which a repetition with the bounds a
+// and b left passes before its next iteration, if there is one. A loop which
+// may match the empty string is entered with an a of 0 at a split of its own.
+func loopSplit(a, b int) (int, bool) {
+	if a > 0 || b == 0 {
+		return 0, false
+	}
+	if b != RANGE_UNBOUND {
+		return b, true
+	}
+	if a == 0 {
+		return 0, true
+	}
+	return -1, true
+}
+
+// visitLoop marks the split a repetition of n with the bounds a and b left
+// passes at at, if there is one.
+func (ctx *Context) visitLoop(n Node, a, b, at int, k *cont) bool {
+	id, ok := loopSplit(a, b)
+	return !ok || ctx.visit(n, id, at, k)
+}
+
+// capture sets the positions of capture i. The old ones go to the trail if
+// a choice may need them back.
+func (ctx *Context) capture(i, a, b int) {
+	if len(ctx.choices) > 0 {
+		ctx.trail = append(ctx.trail, undo{
+			i: i, a: ctx.slots[i*2], b: ctx.slots[i*2+1]})
+	}
+	ctx.slots[i*2], ctx.slots[i*2+1] = a, b
+}
+
+// one matches a single rune.
+func (ctx *Context) one(n single, at int, k *cont) bool {
+	r, w := ctx.step(at)
+	if w == 0 || !n.matchRune(r) {
+		return false
+	}
+	ctx.resume(at+w, k)
+	return true
+}
+
+// seq matches the members of n one after another from member i on.
+func (ctx *Context) seq(n *All, i, at int, k *cont) bool {
+	if i == len(n.n) {
+		ctx.resume(at, k)
+		return true
+	}
+	next := k
+	if i+1 < len(n.n) {
+		next = &cont{kind: contSeq, n: n, i: i + 1, next: k}
+	}
+	return n.n[i].exec(ctx, at, next)
+}
+
+// alt tries the alternatives of n in order from alternative i on.
+func (ctx *Context) alt(n *AnyOf, i, at int, k *cont) bool {
+	if i >= len(n.n) {
+		return false
+	}
+	if i+1 < len(n.n) {
+		ctx.push(at, &cont{kind: contAlt, n: n, i: i + 1, next: k})
+	}
+	return n.n[i].exec(ctx, at, k)
+}
+
+// fewer returns the bounds left after an iteration. Past the minimum, an
+// unbounded repetition has an a of -1.
+func fewer(a, b int) (int, int) {
+	if b == RANGE_UNBOUND {
+		if a <= 1 {
+			return -1, b
+		}
+		return a - 1, b
+	}
+	return a - 1, b - 1
+}
+
+// enter returns the bound a which a repetition of n with the bounds a and b
+// begins with. Like with Go's regexp, a loop which may be skipped but which
+// cannot match the empty string is entered at the split it repeats at.
+func (ctx *Context) enter(n Node, a, b int) int {
+	if a == 0 && b == RANGE_UNBOUND && ctx.cycles && !Nullable(n) {
+		return -1
+	}
+	return a
+}
+
+// greedy matches n at least a and at most b times. The longest repetition is
+// preferred, and each shorter one is tried in turn when backtracking.
+func (ctx *Context) greedy(n Node, at, a, b int, k *cont) bool {
+	if s, ok := n.(single); ok {
+		return ctx.greedyRunes(s, at, a, b, k)
+	}
+	if b == 0 {
+		ctx.resume(at, k)
+		return true
+	}
+	if !ctx.visitLoop(n, a, b, at, k) {
+		return false
+	}
+	if a <= 0 {
+		ctx.push(at, k)
+	}
+	return n.exec(ctx, at, &cont{
+		kind: contGreedy, n: n, a: a, b: b, next: k})
+}
+
+// greedyRunes is like greedy for a single rune matcher. It consumes as many
+// runes as it may at once and then gives them back one at a time.
+func (ctx *Context) greedyRunes(n single, at, a, b int, k *cont) bool {
+	count, lo := 0, at
+	for b == RANGE_UNBOUND || count < b {
+		if count == a {
+			lo = at
+		}
+		r, w := ctx.step(at)
+		if w == 0 || !n.matchRune(r) {
+			break
+		}
+		at += w
+		count++
+	}
+	if count < a {
+		return false
+	}
+	if count == a {
+		lo = at
+	}
+	// The bounds left are kept for the splits passed when giving back.
+	c := &cont{kind: contGiveBack, n: n, at: lo, a: -1, b: b, next: k}
+	if b != RANGE_UNBOUND {
+		c.a, c.b = a-count, b-count
+	}
+	if at > lo {
+		ctx.push(at, c)
+	}
+	if !ctx.visitLoop(n, c.a, c.b, at, k) {
+		return false
+	}
+	ctx.resume(at, k)
+	return true
+}
+
+// lazy is like greedy, but the shortest repetition is preferred, and each
+// longer one is tried in turn when backtracking.
+func (ctx *Context) lazy(n Node, at, a, b int, k *cont) bool {
+	c := &cont{kind: contLazy, n: n, a: a, b: b, next: k}
+	if a > 0 {
+		return c.resume(ctx, at)
+	}
+	if b != 0 {
+		if !ctx.visitLoop(n, a, b, at, k) {
+			return false
+		}
+		ctx.push(at, c)
+	}
+	ctx.resume(at, k)
+	return true
+}
+
+// resume continues the match from at.
+func (c *cont) resume(ctx *Context, at int) bool {
+	switch c.kind {
+	case contSeq:
+		return ctx.seq(c.n.(*All), c.i, at, c.next)
+	case contAlt:
+		return ctx.alt(c.n.(*AnyOf), c.i, at, c.next)
+	case contCapture:
+		ctx.capture(c.i, c.at, at)
+	case contGreedy:
+		a, b := fewer(c.a, c.b)
+		return ctx.greedy(c.n, at, a, b, c.next)
+	case contLazy:
+		return c.n.exec(ctx, at, &cont{
+			kind: contLazyEnd, n: c.n, a: c.a, b: c.b, next: c.next})
+	case contLazyEnd:
+		a, b := fewer(c.a, c.b)
+		return ctx.lazy(c.n, at, a, b, c.next)
+	case contGiveBack:
+		_, w := ctx.input.Back(at)
+		if c.b != RANGE_UNBOUND {
+			c.a, c.b = c.a+1, c.b+1
+		}
+		if at -= w; at > c.at {
+			ctx.push(at, c)
+		}
+		if !ctx.visitLoop(c.n, c.a, c.b, at, c.next) {
+			return false
+		}
+	case contAtomic:
+		ctx.choices = ctx.choices[:c.i]
+	case contEnd:
+		if at != ctx.input.Len() {
+			return false
+		}
+	case contScan:
+		return c.n.(*ScanTry).scan(ctx, at, c)
+	}
+	ctx.resume(at, c.next)
+	return true
+}
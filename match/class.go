@@ -0,0 +1,245 @@
+package match
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+)
+
+// The shorthand classes are defined as in Perl and Go's `regexp', with ASCII
+// contents only. The ranges are inclusive pairs.
+var classes = map[rune][]rune{
+	'd': {'0', '9'},
+	's': {'\t', '\n', '\f', '\r', ' ', ' '},
+	'w': {'0', '9', 'A', 'Z', '_', '_', 'a', 'z'},
+}
+
+// The POSIX classes are defined as in Go's `regexp', with ASCII contents
+// only.
+var posixClasses = map[string][]rune{
+	"alnum":  {'0', '9', 'A', 'Z', 'a', 'z'},
+	"alpha":  {'A', 'Z', 'a', 'z'},
+	"ascii":  {0, 0x7f},
+	"blank":  {'\t', '\t', ' ', ' '},
+	"cntrl":  {0, 0x1f, 0x7f, 0x7f},
+	"digit":  {'0', '9'},
+	"graph":  {'!', '~'},
+	"lower":  {'a', 'z'},
+	"print":  {' ', '~'},
+	"punct":  {'!', '/', ':', '@', '[', '`', '{', '~'},
+	"space":  {'\t', '\r', ' ', ' '},
+	"upper":  {'A', 'Z'},
+	"word":   {'0', '9', 'A', 'Z', '_', '_', 'a', 'z'},
+	"xdigit": {'0', '9', 'A', 'F', 'a', 'f'},
+}
+
+// PosixRanges returns the sorted inclusive range pairs of the POSIX class
+// name, such as "alpha" for [:alpha:], or their complement if negate is
+// set. If there is no such class, nil is returned.
+func PosixRanges(name string, negate bool) []rune {
+	ranges, ok := posixClasses[name]
+	if !ok {
+		return nil
+	}
+	if negate {
+		return Complement(ranges)
+	}
+	return ranges
+}
+
+// ClassRanges returns the sorted inclusive range pairs of the shorthand class
+// name, such as 'd' for \d. An upper case name gives the complement of the
+// lower case one. If there is no such class, nil is returned.
+func ClassRanges(name rune) []rune {
+	if ranges, ok := classes[name]; ok {
+		return ranges
+	}
+	ranges, ok := classes[unicode.ToLower(name)]
+	if !ok {
+		return nil
+	}
+	return Complement(ranges)
+}
+
+// Complement returns the runes which sorted range pairs do not cover.
+func Complement(ranges []rune) []rune {
+	ret := []rune{}
+	next := rune(0)
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] > next {
+			ret = append(ret, next, ranges[i]-1)
+		}
+		next = ranges[i+1] + 1
+	}
+	if next <= unicode.MaxRune {
+		ret = append(ret, next, unicode.MaxRune)
+	}
+	return ret
+}
+
+// anyTable covers every rune for \p{Any}.
+var anyTable = &unicode.RangeTable{
+	R32: []unicode.Range32{{Lo: 0, Hi: unicode.MaxRune, Stride: 1}},
+}
+
+// PropertyTable returns the table of the Unicode general category or script
+// name, such as "Lu" or "Greek". "Any" covers every rune. If there is no such
+// class, nil is returned.
+func PropertyTable(name string) *unicode.RangeTable {
+	if name == "Any" {
+		return anyTable
+	}
+	if t, ok := unicode.Categories[name]; ok {
+		return t
+	}
+	return unicode.Scripts[name]
+}
+
+// TableRanges returns the sorted inclusive range pairs of t, or their
+// complement if negate is set.
+func TableRanges(t *unicode.RangeTable, negate bool) []rune {
+	ret := []rune{}
+	add := func(lo, hi, stride rune) {
+		if stride == 1 {
+			ret = append(ret, lo, hi)
+			return
+		}
+		for r := lo; r <= hi; r += stride {
+			ret = append(ret, r, r)
+		}
+	}
+	for _, r := range t.R16 {
+		add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	for _, r := range t.R32 {
+		add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	if negate {
+		return Complement(ret)
+	}
+	return ret
+}
+
+// Property matches a single rune of a Unicode general category or script,
+// such as \p{Greek}. If negate is set, it matches a single rune outside of
+// it.
+type Property struct {
+	name   string
+	negate bool
+	table  *unicode.RangeTable
+}
+
+func (n *Property) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *Property) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.one(n, at, k)
+}
+
+func (n *Property) matchRune(r rune) bool {
+	return unicode.Is(n.table, r) != n.negate
+}
+
+func (n *Property) Name() string {
+	return n.name
+}
+
+func (n *Property) Negated() bool {
+	return n.negate
+}
+
+func (n *Property) Table() *unicode.RangeTable {
+	return n.table
+}
+
+// NewProperty returns the Unicode class name as found by PropertyTable.
+func NewProperty(name string, negate bool) Node {
+	t := PropertyTable(name)
+	if t == nil {
+		panic(fmt.Sprintf("unknown Unicode class %q", name))
+	}
+	return &Property{name: name, negate: negate, table: t}
+}
+
+// Only the runes from minFold to maxFold fold to other runes.
+const (
+	minFold = 0x0041
+	maxFold = 0x1e943
+)
+
+// Fold returns r and the runes it folds to with unicode.SimpleFold, sorted.
+func Fold(r rune) []rune {
+	ret := []rune{r}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		ret = append(ret, f)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+	return ret
+}
+
+// FoldRanges returns the sorted inclusive range pairs which cover ranges and
+// every rune they fold to.
+func FoldRanges(ranges []rune) []rune {
+	ret := []rune{}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		ret = append(ret, lo, hi)
+		// A range covering all the folding runes has nothing to add.
+		if lo <= minFold && hi >= maxFold {
+			continue
+		}
+		if lo < minFold {
+			lo = minFold
+		}
+		if hi > maxFold {
+			hi = maxFold
+		}
+		for r := lo; r <= hi; r++ {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				ret = append(ret, f, f)
+			}
+		}
+	}
+	return mergeRanges(ret)
+}
+
+// Class matches a single rune of a shorthand class such as \d.
+type Class struct {
+	name  rune
+	table *RangeTable
+}
+
+func (n *Class) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *Class) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.one(n, at, k)
+}
+
+func (n *Class) matchRune(r rune) bool {
+	return n.table.matchRune(r)
+}
+
+// Name returns the letter of the class, such as 'd' for \d.
+func (n *Class) Name() rune {
+	return n.name
+}
+
+// Ranges returns the inclusive range pairs of the runes the class matches.
+func (n *Class) Ranges() []rune {
+	return n.table.ranges
+}
+
+// NewClass returns the shorthand class name, such as 'd' for \d and 'D' for
+// \D.
+func NewClass(name rune) Node {
+	ranges := ClassRanges(name)
+	if ranges == nil {
+		panic(fmt.Sprintf("unknown class \\%c", name))
+	}
+	return &Class{name: name, table: &RangeTable{ranges: ranges}}
+}
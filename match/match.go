@@ -1,29 +1,58 @@
 package match
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/susji/mre/input"
 )
 
 const RANGE_UNBOUND = -1
 
+var errNoMatch = errors.New("no match")
+
 // Interface node describes how a regular expression submatcher should behave.
 type Node interface {
-	// Match accepts something to parse and returns a possibly
-	// non-empty list of matched rune slices, what's left to parse, and a
-	// possible error.
-	Match(*Context, []rune) ([][]rune, []rune, error)
-}
-
+	// Match accepts something to parse and returns what was matched,
+	// what's left to parse, and a possible error. Only the preferred way of
+	// matching is reported.
+	Match(*Context, string) (string, string, error)
+	// exec begins matching the node at byte offset at of the context input
+	// and arranges for k to continue from where it ends. Other ways of
+	// matching are left on the context as choices in the order of
+	// preference. It returns false if the node cannot match at all. A nil
+	// k accepts the match.
+	exec(ctx *Context, at int, k *cont) bool
+}
+
+// Context holds the state of a single match. For each capture id, there is
+// a pair of slots for the start and end positions of what it matched. If the
+// capture matched several times, the last one counts.
 type Context struct {
 	ncapturers int
-	captures   [][]rune
+	slots      []int
+	input      input.Input
+	// The backtracker resumes k at at next. See backtrack.go.
+	k       *cont
+	at      int
+	choices []choice
+	trail   []undo
+	marks   []mark
+	cycles  bool
 }
 
 type TimesFunc func(Node) Node
 
 type Root struct {
-	n Node
+	n          Node
+	ncapturers int
+	anchored   bool
+	// required is a literal which every match contains.
+	required string
+	// cycles is set if n may pass a split twice at the same position.
+	cycles bool
 }
 
 type Capture struct {
@@ -35,8 +64,13 @@ type Exhaustive struct {
 	n Node
 }
 
+// ScanTry tries n at each position of input. If every match of n begins
+// with one of a set of literal prefixes, only the positions where they occur
+// are tried.
 type ScanTry struct {
-	n Node
+	n        Node
+	prefixes []string
+	pf       Prefilter
 }
 
 type N struct {
@@ -49,6 +83,19 @@ type LengthRange struct {
 	n    Node
 }
 
+// Lazy matches n at least a and at most b times, preferring fewer
+// repetitions over more.
+type Lazy struct {
+	a, b int
+	n    Node
+}
+
+// Atomic matches n only in the way it prefers. If the rest of the
+// expression fails, n is not tried in other ways.
+type Atomic struct {
+	n Node
+}
+
 type ZeroOrOne struct {
 	n Node
 }
@@ -65,6 +112,29 @@ type AnyOf struct {
 	n []Node
 }
 
+type AssertKind uint8
+
+const (
+	// ASSERT_BEGIN and ASSERT_END match at the beginning and at the end of
+	// input.
+	ASSERT_BEGIN = AssertKind(iota)
+	ASSERT_END
+	// ASSERT_BEGIN_LINE and ASSERT_END_LINE also match right after and
+	// right before a '\n'.
+	ASSERT_BEGIN_LINE
+	ASSERT_END_LINE
+)
+
+// Assert matches the empty string at positions of the kind it asserts.
+type Assert struct {
+	kind AssertKind
+}
+
+// NoneOf matches a single rune which none of its members match.
+type NoneOf struct {
+	n []Node
+}
+
 type NotRune struct {
 	r rune
 }
@@ -84,12 +154,38 @@ type RuneRange struct {
 	a, b rune
 }
 
+// String matches its runes one after another.
+type String struct {
+	r []rune
+}
+
+// RangeTable matches a single rune which falls into one of its ranges. The
+// ranges are inclusive pairs sorted by their start without any overlap. If
+// negate is set, it matches a single rune which falls into none of them.
+type RangeTable struct {
+	ranges []rune
+	negate bool
+}
+
 func (ctx *Context) Reset() {
-	ctx.captures = make([][]rune, ctx.ncapturers)
+	if len(ctx.slots) != ctx.ncapturers*2 {
+		ctx.slots = make([]int, ctx.ncapturers*2)
+	}
+	for i := range ctx.slots {
+		ctx.slots[i] = -1
+	}
+}
+
+// Slots returns the capture positions of the last match. Positions of
+// captures which did not take part in the match are -1.
+func (ctx *Context) Slots() []int {
+	return ctx.slots
 }
 
-func (ctx *Context) Captures() [][]rune {
-	return ctx.captures
+// step returns the rune at byte offset at and its width. The width is zero
+// if there is nothing left.
+func (ctx *Context) step(at int) (rune, int) {
+	return ctx.input.Step(at)
 }
 
 func dump(n Node, b *strings.Builder, level int) {
@@ -121,6 +217,11 @@ func dump(n Node, b *strings.Builder, level int) {
 		for _, oo := range v.n {
 			rec(oo)
 		}
+	case *NoneOf:
+		w("none")
+		for _, oo := range v.n {
+			rec(oo)
+		}
 	case *NotRune:
 		w(fmt.Sprintf("! '%c'", v.r))
 	case *N:
@@ -136,6 +237,14 @@ func dump(n Node, b *strings.Builder, level int) {
 	case *LengthRange:
 		w(fmt.Sprintf("{%d,%d}", v.a, v.b))
 		rec(v.n)
+	case *Lazy:
+		w(fmt.Sprintf("{%d,%d}?", v.a, v.b))
+		rec(v.n)
+	case *Atomic:
+		w("atomic")
+		rec(v.n)
+	case *Assert:
+		w([]string{"^", "$", "^/m", "$/m"}[v.kind])
 	case *ScanTry:
 		w("scantry")
 		rec(v.n)
@@ -152,6 +261,31 @@ func dump(n Node, b *strings.Builder, level int) {
 		w(fmt.Sprintf("'%c'-'%c'", v.a, v.b))
 	case *Rune:
 		w(fmt.Sprintf("'%c'", v.r))
+	case *String:
+		w(fmt.Sprintf("%q", string(v.r)))
+	case *Class:
+		w(fmt.Sprintf("\\%c", v.name))
+	case *Property:
+		if v.negate {
+			w(fmt.Sprintf("\\P{%s}", v.name))
+		} else {
+			w(fmt.Sprintf("\\p{%s}", v.name))
+		}
+	case *RangeTable:
+		s := &strings.Builder{}
+		s.WriteRune('[')
+		if v.negate {
+			s.WriteRune('^')
+		}
+		for i := 0; i < len(v.ranges); i += 2 {
+			if v.ranges[i] == v.ranges[i+1] {
+				fmt.Fprintf(s, "'%c'", v.ranges[i])
+			} else {
+				fmt.Fprintf(s, "'%c'-'%c'", v.ranges[i], v.ranges[i+1])
+			}
+		}
+		s.WriteRune(']')
+		w(s.String())
 	default:
 		panic(fmt.Sprintf("missing case for %T", n))
 	}
@@ -164,196 +298,601 @@ func Dump(n Node) string {
 	return b.String()
 }
 
-func (n *Root) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	res, left, err := n.n.Match(ctx, expr)
-	if err != nil {
-		return nil, nil, fmt.Errorf("cannot match: %w", err)
+// ncapturers returns the number of capture ids needed by the tree n.
+func ncapturers(n Node) int {
+	max := func(nodes ...Node) int {
+		ret := 0
+		for _, nn := range nodes {
+			if c := ncapturers(nn); c > ret {
+				ret = c
+			}
+		}
+		return ret
+	}
+	switch v := n.(type) {
+	case *Capture:
+		if c := ncapturers(v.n); c > v.id+1 {
+			return c
+		}
+		return v.id + 1
+	case *Root:
+		return max(v.n)
+	case *Exhaustive:
+		return max(v.n)
+	case *ScanTry:
+		return max(v.n)
+	case *N:
+		return max(v.n)
+	case *LengthRange:
+		return max(v.n)
+	case *Lazy:
+		return max(v.n)
+	case *Atomic:
+		return max(v.n)
+	case *ZeroOrOne:
+		return max(v.n)
+	case *OneOrMore:
+		return max(v.n)
+	case *ZeroOrMore:
+		return max(v.n)
+	case *AnyOf:
+		return max(v.n...)
+	case *All:
+		return max(v.n...)
 	}
-	// Root node always returns the whole argument if we matched.
-	ret := [][]rune{expr}
-	return append(ret, res...), left, nil
+	return 0
 }
 
-func (n *ZeroOrOne) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	res, left, err := n.n.Match(ctx, expr)
-	if err != nil {
-		return nil, expr, nil
-	} else {
-		return res, left, nil
+// anchored tells if the tree n may only match at the very beginning of input.
+// Unless it scans for a starting position, it is anchored.
+func anchored(n Node) bool {
+	if e, ok := n.(*Exhaustive); ok {
+		n = e.n
 	}
+	_, ok := n.(*ScanTry)
+	return !ok
 }
 
-func (n *ZeroOrMore) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	total := [][]rune{}
-	for {
-		res, left, err := n.n.Match(ctx, expr)
-		total = append(total, res...)
-		if err != nil {
-			return total, left, nil
+// Nullable tells if the tree n may match the empty string.
+func Nullable(n Node) bool {
+	switch v := n.(type) {
+	case *Capture:
+		return Nullable(v.n)
+	case *Root:
+		return Nullable(v.n)
+	case *Exhaustive:
+		return Nullable(v.n)
+	case *ScanTry:
+		return Nullable(v.n)
+	case *N:
+		return v.a == 0 || Nullable(v.n)
+	case *LengthRange:
+		return v.a == 0 || Nullable(v.n)
+	case *Lazy:
+		return v.a == 0 || Nullable(v.n)
+	case *Atomic:
+		return Nullable(v.n)
+	case *OneOrMore:
+		return Nullable(v.n)
+	case *ZeroOrOne, *ZeroOrMore, *Assert:
+		return true
+	case *String:
+		return len(v.r) == 0
+	case *AnyOf:
+		for _, nn := range v.n {
+			if Nullable(nn) {
+				return true
+			}
 		}
-		expr = left
+		return false
+	case *All:
+		for _, nn := range v.n {
+			if !Nullable(nn) {
+				return false
+			}
+		}
+		return true
 	}
+	return false
 }
 
-func (n *OneOrMore) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	matches := 0
-	total := [][]rune{}
-	for {
-		res, left, err := n.n.Match(ctx, expr)
-		total = append(total, res...)
-		if err != nil {
-			break
+// cycles tells if the tree n repeats without a bound something which may
+// match the empty string. Only then may a split be passed twice at the same
+// position.
+func cycles(n Node) bool {
+	switch v := n.(type) {
+	case *ZeroOrMore:
+		return Nullable(v.n) || cycles(v.n)
+	case *OneOrMore:
+		return Nullable(v.n) || cycles(v.n)
+	case *LengthRange:
+		return v.b == RANGE_UNBOUND && Nullable(v.n) || cycles(v.n)
+	case *Lazy:
+		return v.b == RANGE_UNBOUND && Nullable(v.n) || cycles(v.n)
+	case *Capture:
+		return cycles(v.n)
+	case *Root:
+		return cycles(v.n)
+	case *Exhaustive:
+		return cycles(v.n)
+	case *ScanTry:
+		return cycles(v.n)
+	case *N:
+		return cycles(v.n)
+	case *Atomic:
+		return cycles(v.n)
+	case *ZeroOrOne:
+		return cycles(v.n)
+	case *AnyOf:
+		for _, nn := range v.n {
+			if cycles(nn) {
+				return true
+			}
+		}
+	case *All:
+		for _, nn := range v.n {
+			if cycles(nn) {
+				return true
+			}
 		}
-		expr = left
-		matches++
 	}
-	if matches == 0 {
-		return nil, expr, fmt.Errorf("one-or-more: zero matches")
+	return false
+}
+
+// first matches n against the beginning of expr and reports the first, that
+// is, the preferred way of matching.
+func first(n Node, ctx *Context, expr string) (string, string, error) {
+	ctx.input, ctx.cycles = input.String(expr), cycles(n)
+	end, ok := ctx.run(n, 0)
+	if !ok {
+		return "", expr, errNoMatch
 	}
-	return total, expr, nil
-}
-
-func (n *N) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	total := [][]rune{}
-	left := expr
-	for i := 0; i < n.a; i++ {
-		var err error
-		var res [][]rune
-		res, left, err = n.n.Match(ctx, left)
-		total = append(total, res...)
-		if err != nil {
-			return nil, expr, fmt.Errorf("N: not matched")
-		}
+	return expr[:end], expr[end:], nil
+}
+
+func (n *Root) Match(ctx *Context, expr string) (string, string, error) {
+	res, left, err := first(n, ctx, expr)
+	if err != nil {
+		return "", expr, fmt.Errorf("cannot match: %w", err)
 	}
-	return total, left, nil
-}
-
-func (n *LengthRange) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	matches := 0
-	total := [][]rune{}
-	left := expr
-	for i := 0; i < n.b; i++ {
-		var err error
-		var res [][]rune
-		res, left, err = n.n.Match(ctx, left)
-		total = append(total, res...)
-		if err != nil {
-			break
-		}
-		matches++
+	return res, left, nil
+}
+
+func (n *Root) exec(ctx *Context, at int, k *cont) bool {
+	return n.n.exec(ctx, at, k)
+}
+
+// Find looks for the preferred match in input starting from byte offset at. If
+// one is found, its capture positions are left in ctx.
+func (n *Root) Find(ctx *Context, in input.Input, at int) bool {
+	ctx.Reset()
+	if at > 0 && n.anchored {
+		return false
 	}
-	if matches < n.a {
-		return nil, expr, fmt.Errorf("length-range: not within range")
+	if n.required != "" && in.Index(n.required, at) < 0 {
+		return false
 	}
-	return total, left, nil
+	ctx.input, ctx.cycles = in, n.cycles
+	_, ok := ctx.run(n, at)
+	return ok
 }
 
-func (n *AnyOf) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	for _, nn := range n.n {
-		res, left, err := nn.Match(ctx, expr)
-		if err != nil {
-			continue
-		}
-		return res, left, err
+func (n *ZeroOrOne) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *ZeroOrOne) exec(ctx *Context, at int, k *cont) bool {
+	if !ctx.visit(n, splitNode, at, k) {
+		return false
 	}
-	return nil, expr, fmt.Errorf("one-of: nothing matched")
+	ctx.push(at, k)
+	return n.n.exec(ctx, at, k)
+}
+
+func (n *ZeroOrMore) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
 }
 
-func (n *NotRune) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	if len(expr) == 0 {
-		return nil, expr, fmt.Errorf("not-rune: empty expr")
+func (n *ZeroOrMore) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.greedy(n.n, at, ctx.enter(n.n, 0, RANGE_UNBOUND), RANGE_UNBOUND, k)
+}
+
+func (n *OneOrMore) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *OneOrMore) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.greedy(n.n, at, 1, RANGE_UNBOUND, k)
+}
+
+func (n *N) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *N) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.greedy(n.n, at, n.a, n.a, k)
+}
+
+func (n *LengthRange) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *LengthRange) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.greedy(n.n, at, ctx.enter(n.n, n.a, n.b), n.b, k)
+}
+
+func (n *Lazy) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *Lazy) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.lazy(n.n, at, ctx.enter(n.n, n.a, n.b), n.b, k)
+}
+
+func (n *Atomic) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *Atomic) exec(ctx *Context, at int, k *cont) bool {
+	// Once n has matched, the choices it left are dropped. What it captured
+	// is still restored if an earlier choice is taken.
+	return n.n.exec(ctx, at, &cont{
+		kind: contAtomic, i: len(ctx.choices), next: k})
+}
+
+func (n *Assert) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *Assert) exec(ctx *Context, at int, k *cont) bool {
+	if !n.At(ctx.input, at) {
+		return false
 	}
-	if expr[0] != n.r {
-		return [][]rune{[]rune{expr[0]}}, expr[1:], nil
+	ctx.resume(at, k)
+	return true
+}
+
+// At tells if the assertion holds at byte offset at of in. As '\n' is a
+// single byte in UTF-8, the byte before at is enough to look at.
+func (n *Assert) At(in input.Input, at int) bool {
+	switch n.kind {
+	case ASSERT_BEGIN:
+		return at == 0
+	case ASSERT_END:
+		return at == in.Len()
+	case ASSERT_BEGIN_LINE:
+		if at == 0 {
+			return true
+		}
+		r, _ := in.Step(at - 1)
+		return r == '\n'
+	default:
+		r, w := in.Step(at)
+		return w == 0 || r == '\n'
 	}
-	return nil, expr, fmt.Errorf("not-rune: wanted to avoid %c, but got it", n.r)
 }
 
-func (n *Capture) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	if len(expr) == 0 {
-		return nil, expr, fmt.Errorf("capture: empty expr")
+func (n *AnyOf) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *AnyOf) exec(ctx *Context, at int, k *cont) bool {
+	if !ctx.visit(n, splitNode, at, k) {
+		return false
 	}
-	res, left, err := n.n.Match(ctx, expr)
-	if err == nil && len(res) > 0 {
-		for _, sr := range res {
-			ctx.captures[n.id] = append(ctx.captures[n.id], sr...)
+	return ctx.alt(n, 0, at, k)
+}
+
+func (n *NoneOf) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *NoneOf) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.one(n, at, k)
+}
+
+// matchRune tells if none of the members match r. The members are all
+// single rune matchers.
+func (n *NoneOf) matchRune(r rune) bool {
+	for _, nn := range n.n {
+		if s, ok := nn.(single); ok && s.matchRune(r) {
+			return false
 		}
 	}
-	return res, left, err
+	return true
 }
 
-func (n *All) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	total := [][]rune{}
-	left := expr
-	for _, nn := range n.n {
-		var err error
-		var res [][]rune
+func (n *NotRune) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *NotRune) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.one(n, at, k)
+}
+
+func (n *NotRune) matchRune(r rune) bool {
+	return r != n.r
+}
+
+func (n *Capture) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *Capture) exec(ctx *Context, at int, k *cont) bool {
+	return n.n.exec(ctx, at, &cont{kind: contCapture, i: n.id, at: at, next: k})
+}
+
+func (n *All) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *All) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.seq(n, 0, at, k)
+}
 
-		res, left, err = nn.Match(ctx, left)
-		if err != nil {
-			return nil, expr, fmt.Errorf("all: mismatch")
+func (n *Rune) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *Rune) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.one(n, at, k)
+}
+
+func (n *Rune) matchRune(r rune) bool {
+	return r == n.r
+}
+
+func (n *RuneRange) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *RuneRange) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.one(n, at, k)
+}
+
+func (n *RuneRange) matchRune(r rune) bool {
+	return r >= n.a && r <= n.b
+}
+
+func (n *String) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *String) exec(ctx *Context, at int, k *cont) bool {
+	for _, want := range n.r {
+		r, w := ctx.step(at)
+		if w == 0 || r != want {
+			return false
 		}
-		total = append(total, res...)
+		at += w
 	}
-	return total, left, nil
+	ctx.resume(at, k)
+	return true
 }
 
-func (n *Rune) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	if len(expr) == 0 {
-		return nil, expr, fmt.Errorf("rune: empty expr")
-	}
-	if expr[0] == n.r {
-		return [][]rune{[]rune{expr[0]}}, expr[1:], nil
-	}
-	return nil, expr, fmt.Errorf("rune: wanted %c, got %c", n.r, expr[0])
+func (n *RangeTable) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
 }
 
-func (n *RuneRange) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	if len(expr) == 0 {
-		return nil, expr, fmt.Errorf("rune-range: empty expr")
+func (n *RangeTable) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.one(n, at, k)
+}
+
+func (n *RangeTable) matchRune(r rune) bool {
+	return n.contains(r) != n.negate
+}
+
+// contains tells if r falls into one of the ranges.
+func (n *RangeTable) contains(r rune) bool {
+	npairs := len(n.ranges) / 2
+	i := sort.Search(npairs, func(i int) bool {
+		return n.ranges[i*2+1] >= r
+	})
+	return i < npairs && n.ranges[i*2] <= r
+}
+
+func (n *Any) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *Any) exec(ctx *Context, at int, k *cont) bool {
+	return ctx.one(n, at, k)
+}
+
+func (n *Any) matchRune(r rune) bool {
+	return true
+}
+
+func (n *Exhaustive) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *Exhaustive) exec(ctx *Context, at int, k *cont) bool {
+	return n.n.exec(ctx, at, &cont{kind: contEnd, next: k})
+}
+
+func (n *ScanTry) Match(ctx *Context, expr string) (string, string, error) {
+	return first(n, ctx, expr)
+}
+
+func (n *ScanTry) exec(ctx *Context, at int, k *cont) bool {
+	return n.scan(ctx, at, &cont{kind: contScan, n: n, next: k})
+}
+
+// scan tries n from the first position at or after at where the prefilter
+// says a match may begin. Trying from the next position is left as a choice
+// resuming c.
+func (n *ScanTry) scan(ctx *Context, at int, c *cont) bool {
+	if n.pf != nil {
+		if at = n.pf.Index(ctx.input, at); at < 0 {
+			return false
+		}
 	}
-	if expr[0] >= n.a && expr[0] <= n.b {
-		return [][]rune{[]rune{expr[0]}}, expr[1:], nil
+	// The end of input is a valid starting point, too, as the subexpression
+	// may match the empty string.
+	if _, w := ctx.step(at); w > 0 {
+		ctx.push(at+w, c)
 	}
-	return nil, expr, fmt.Errorf("rune-range: wanted %c-%c, got %c",
-		n.a, n.b, expr[0])
+	return n.n.exec(ctx, at, c.next)
 }
 
-func (n *Any) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	if len(expr) == 0 {
-		return nil, expr, fmt.Errorf("any: empty expr")
-	}
-	return [][]rune{[]rune{expr[0]}}, expr[1:], nil
+// The accessors below expose the matcher tree to other execution engines
+// which compile it into their own form.
+
+func (n *Root) Sub() Node {
+	return n.n
 }
 
-func (n *Exhaustive) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	if len(expr) == 0 {
-		return nil, expr, fmt.Errorf("empty: empty expr")
-	}
-	res, left, err := n.n.Match(ctx, expr)
-	if err != nil {
-		return nil, expr, err
+// Anchored tells if the tree only matches at the beginning of input.
+func (n *Root) Anchored() bool {
+	return n.anchored
+}
+
+// scan returns the ScanTry of an unanchored tree, or nil if it is anchored.
+func (n *Root) scan() *ScanTry {
+	sub := n.n
+	if e, ok := sub.(*Exhaustive); ok {
+		sub = e.n
 	}
-	if len(left) > 0 {
-		return nil, expr, fmt.Errorf("exhaustive: not exhaustive")
+	s, _ := sub.(*ScanTry)
+	return s
+}
+
+// Prefixes returns literals one of which every match of an unanchored tree
+// begins with. It is nil if there are none.
+func (n *Root) Prefixes() []string {
+	if s := n.scan(); s != nil {
+		return s.prefixes
 	}
-	return res, left, nil
+	return nil
 }
 
-func (n *ScanTry) Match(ctx *Context, expr []rune) ([][]rune, []rune, error) {
-	cur := expr
-	for len(cur) > 0 {
-		res, left, err := n.n.Match(ctx, cur)
-		if err == nil {
-			return res, left, err
-		}
-		cur = cur[1:]
+// Prefilter returns what an unanchored tree uses to find the positions where
+// a match may begin. It is nil if there is none.
+func (n *Root) Prefilter() Prefilter {
+	if s := n.scan(); s != nil {
+		return s.pf
 	}
-	return nil, expr, fmt.Errorf("scan-try: no match")
+	return nil
+}
+
+// Required returns a literal which every match contains. It is empty if there
+// is none.
+func (n *Root) Required() string {
+	return n.required
+}
+
+// NewContext returns a fresh context with room for the captures of the tree.
+// A context may be reused for matching once the previous match is done with
+// it, but it must not be used by two matches at the same time.
+func (n *Root) NewContext() *Context {
+	return NewContext(n.ncapturers)
+}
+
+func (n *Capture) Sub() Node {
+	return n.n
+}
+
+func (n *Capture) ID() int {
+	return n.id
+}
+
+func (n *Exhaustive) Sub() Node {
+	return n.n
+}
+
+func (n *ScanTry) Sub() Node {
+	return n.n
+}
+
+func (n *Atomic) Sub() Node {
+	return n.n
+}
+
+func (n *N) Sub() Node {
+	return n.n
+}
+
+func (n *N) Count() int {
+	return n.a
+}
+
+func (n *LengthRange) Sub() Node {
+	return n.n
+}
+
+// Bounds returns the minimum and maximum counts. The maximum may be
+// RANGE_UNBOUND.
+func (n *LengthRange) Bounds() (int, int) {
+	return n.a, n.b
+}
+
+func (n *Lazy) Sub() Node {
+	return n.n
+}
+
+// Bounds returns the minimum and maximum counts. The maximum may be
+// RANGE_UNBOUND.
+func (n *Lazy) Bounds() (int, int) {
+	return n.a, n.b
+}
+
+func (n *Assert) Kind() AssertKind {
+	return n.kind
+}
+
+func (n *ZeroOrOne) Sub() Node {
+	return n.n
+}
+
+func (n *OneOrMore) Sub() Node {
+	return n.n
+}
+
+func (n *ZeroOrMore) Sub() Node {
+	return n.n
+}
+
+func (n *AnyOf) Subs() []Node {
+	return n.n
+}
+
+func (n *NoneOf) Subs() []Node {
+	return n.n
+}
+
+func (n *All) Subs() []Node {
+	return n.n
+}
+
+func (n *NotRune) Rune() rune {
+	return n.r
+}
+
+func (n *Rune) Rune() rune {
+	return n.r
+}
+
+func (n *RuneRange) Range() (rune, rune) {
+	return n.a, n.b
+}
+
+func (n *String) Runes() []rune {
+	return n.r
+}
+
+// Ranges returns the inclusive range pairs of the table.
+func (n *RangeTable) Ranges() []rune {
+	return n.ranges
+}
+
+func (n *RangeTable) Negated() bool {
+	return n.negate
 }
 
 func NewScanTry(n Node) Node {
-	return &ScanTry{n: n}
+	lits := searchablePrefixes(prefixes(n))
+	return &ScanTry{n: n, prefixes: lits, pf: newPrefilter(lits)}
 }
 
 func NewN(n Node, a int) Node {
@@ -364,6 +903,18 @@ func NewLengthRange(n Node, a, b int) Node {
 	return &LengthRange{n: n, a: a, b: b}
 }
 
+func NewLazy(n Node, a, b int) Node {
+	return &Lazy{n: n, a: a, b: b}
+}
+
+func NewAtomic(n Node) Node {
+	return &Atomic{n: n}
+}
+
+func NewAssert(kind AssertKind) Node {
+	return &Assert{kind: kind}
+}
+
 func NewZeroOrOne(n Node) Node {
 	return &ZeroOrOne{n: n}
 }
@@ -384,6 +935,10 @@ func NewAll(n ...Node) Node {
 	return &All{n: n}
 }
 
+func NewNoneOf(n ...Node) Node {
+	return &NoneOf{n: n}
+}
+
 func NewNotRune(r rune) Node {
 	return &NotRune{r: r}
 }
@@ -396,6 +951,16 @@ func NewRuneRange(a, b rune) Node {
 	return &RuneRange{a: a, b: b}
 }
 
+func NewString(s string) Node {
+	return &String{r: []rune(s)}
+}
+
+// NewRangeTable builds a table out of inclusive range pairs. The ranges may
+// come in any order and overlap.
+func NewRangeTable(negate bool, ranges ...rune) Node {
+	return &RangeTable{ranges: mergeRanges(ranges), negate: negate}
+}
+
 func NewAny() Node {
 	return &Any{}
 }
@@ -409,9 +974,17 @@ func NewCapture(n Node, id int) Node {
 }
 
 func NewRoot(n Node) Node {
-	return &Root{n: n}
+	return &Root{
+		n:          n,
+		ncapturers: ncapturers(n),
+		anchored:   anchored(n),
+		required:   required(n),
+		cycles:     cycles(n),
+	}
 }
 
 func NewContext(ncapturers int) *Context {
-	return &Context{ncapturers: ncapturers}
+	ctx := &Context{ncapturers: ncapturers}
+	ctx.Reset()
+	return ctx
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/susji/mre/input"
 	"github.com/susji/mre/match"
 )
 
@@ -123,13 +124,59 @@ func TestMatchers(t *testing.T) {
 			yes:     [][]rune{[]rune("a"), []rune("aabbccd")},
 			no:      [][]rune{[]rune(" a"), []rune(" ")},
 		},
+		{
+			matcher: match.NewNoneOf(
+				match.NewRune('a'),
+				match.NewRuneRange('0', '9')),
+			desc: "[^a0-9]",
+			yes:  [][]rune{[]rune("b"), []rune("ba")},
+			no:   [][]rune{[]rune("a"), []rune("5"), []rune("")},
+		},
+		{
+			matcher: match.NewExhaustive(
+				match.NewAll(
+					match.NewZeroOrMore(match.NewRune('a')),
+					match.NewRune('a'),
+					match.NewRune('b'))),
+			desc: "a*ab$",
+			yes:  [][]rune{[]rune("ab"), []rune("aaab")},
+			no:   [][]rune{[]rune("b"), []rune("aaa"), []rune("aaaba")},
+		},
+		{
+			matcher: match.NewExhaustive(
+				match.NewAll(
+					match.NewLengthRange(match.NewAny(), 1, 3),
+					match.NewRune('c'))),
+			desc: ".{1,3}c$",
+			yes:  [][]rune{[]rune("ac"), []rune("abcc"), []rune("ccc")},
+			no:   [][]rune{[]rune("c"), []rune("abcdc")},
+		},
+		{
+			matcher: match.NewExhaustive(
+				match.NewAll(
+					match.NewAnyOf(
+						match.NewRune('a'),
+						match.NewAll(match.NewRune('a'), match.NewRune('b'))),
+					match.NewRune('c'))),
+			desc: "(a|ab)c$",
+			yes:  [][]rune{[]rune("ac"), []rune("abc")},
+			no:   [][]rune{[]rune("abbc"), []rune("bc")},
+		},
+		{
+			matcher: match.NewExhaustive(
+				match.NewScanTry(
+					match.NewOneOrMore(
+						match.NewZeroOrMore(match.NewRune('a'))))),
+			desc: "(a*)+$",
+			yes:  [][]rune{[]rune(""), []rune("aaa"), []rune("ba")},
+		},
 	}
 
 	for _, te := range table {
 		t.Run(te.desc, func(t *testing.T) {
 			for _, y := range te.yes {
 				t.Run("should_match_"+string(y), func(t *testing.T) {
-					_, _, err := te.matcher.Match(match.NewContext(0), y)
+					_, _, err := te.matcher.Match(match.NewContext(0), string(y))
 					if err != nil {
 						t.Error("did NOT match")
 					}
@@ -137,7 +184,7 @@ func TestMatchers(t *testing.T) {
 			}
 			for _, n := range te.no {
 				t.Run("should_NOT_match_"+string(n), func(t *testing.T) {
-					_, _, err := te.matcher.Match(match.NewContext(0), n)
+					_, _, err := te.matcher.Match(match.NewContext(0), string(n))
 					if err == nil {
 						t.Error("DID match")
 					}
@@ -217,6 +264,15 @@ func TestMatchersLeft(t *testing.T) {
 			test: []rune("z"),
 			left: []rune("z"),
 		},
+		{
+			matcher: match.NewAll(
+				match.NewRune('ö'),
+				match.NewAny(),
+				match.NewNoneOf(match.NewRune('a'))),
+			desc: "ö.[^a]",
+			test: []rune("öä€x"),
+			left: []rune("x"),
+		},
 	}
 
 	for _, te := range table {
@@ -227,17 +283,80 @@ func TestMatchersLeft(t *testing.T) {
 				string(te.test),
 				string(te.left)),
 			func(t *testing.T) {
-				_, left, err := te.matcher.Match(match.NewContext(0), te.test)
+				_, left, err := te.matcher.Match(match.NewContext(0), string(te.test))
 				if err != nil {
 					t.Error("should not error")
 				}
-				if string(left) != string(te.left) {
+				if left != string(te.left) {
 					t.Errorf(
 						"wanted %s but left %s",
 						string(te.left),
-						string(left))
+						left)
 				}
 			})
 	}
 
 }
+
+func TestSlots(t *testing.T) {
+	// ^((a)|b)+c
+	root := match.NewRoot(
+		match.NewCapture(
+			match.NewAll(
+				match.NewOneOrMore(
+					match.NewCapture(
+						match.NewAnyOf(
+							match.NewCapture(match.NewRune('a'), 2),
+							match.NewRune('b')), 1)),
+				match.NewRune('c')), 0)).(*match.Root)
+	ctx := root.NewContext()
+	if !root.Find(ctx, input.String("abac"), 0) {
+		t.Fatal("did NOT match")
+	}
+	// The last iteration of the repetition counts.
+	want := []int{0, 4, 2, 3, 2, 3}
+	if got := ctx.Slots(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+	// An anchored tree may not match later.
+	if root.Find(ctx, input.String("xabac"), 1) {
+		t.Error("DID match")
+	}
+	// A capture which did not take part in the last iteration keeps what
+	// it matched earlier.
+	if !root.Find(ctx, input.String("abbc"), 0) {
+		t.Fatal("did NOT match")
+	}
+	want = []int{0, 4, 2, 3, 0, 1}
+	if got := ctx.Slots(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+}
+
+func TestNullable(t *testing.T) {
+	a, b := match.NewRune('a'), match.NewRune('b')
+	table := []struct {
+		desc string
+		n    match.Node
+		want bool
+	}{
+		{"a", a, false},
+		{"a*", match.NewZeroOrMore(a), true},
+		{"a+", match.NewOneOrMore(a), false},
+		{"(a?)+", match.NewOneOrMore(match.NewCapture(match.NewZeroOrOne(a), 1)), true},
+		{"a{0,2}", match.NewLengthRange(a, 0, 2), true},
+		{"a{1,2}", match.NewLazy(a, 1, 2), false},
+		{"a|b", match.NewAnyOf(a, b), false},
+		{"a|b*", match.NewAnyOf(a, match.NewZeroOrMore(b)), true},
+		{"a*b", match.NewAll(match.NewZeroOrMore(a), b), false},
+		{"^a*$", match.NewAll(
+			match.NewAssert(match.ASSERT_BEGIN),
+			match.NewZeroOrMore(a),
+			match.NewAssert(match.ASSERT_END)), true},
+	}
+	for _, te := range table {
+		if got := match.Nullable(te.n); got != te.want {
+			t.Errorf("%s: wanted %v, got %v", te.desc, te.want, got)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package match
+
+import "sort"
+
+// Optimize returns a simpler tree which matches the same way as root. It
+//   - flattens nested All and AnyOf nodes
+//   - merges adjacent runes into strings
+//   - turns sets and alternations of single runes into range tables
+//   - factors common literal prefixes out of alternations, so that
+//     abc|abd becomes ab[cd].
+func Optimize(root *Root) *Root {
+	return NewRoot(optimize(root.n)).(*Root)
+}
+
+func optimize(n Node) Node {
+	switch v := n.(type) {
+	case *Capture:
+		return NewCapture(optimize(v.n), v.id)
+	case *Exhaustive:
+		return NewExhaustive(optimize(v.n))
+	case *ScanTry:
+		return NewScanTry(optimize(v.n))
+	case *N:
+		return NewN(optimize(v.n), v.a)
+	case *LengthRange:
+		return NewLengthRange(optimize(v.n), v.a, v.b)
+	case *Lazy:
+		return NewLazy(optimize(v.n), v.a, v.b)
+	case *Atomic:
+		return NewAtomic(optimize(v.n))
+	case *ZeroOrOne:
+		return NewZeroOrOne(optimize(v.n))
+	case *ZeroOrMore:
+		return NewZeroOrMore(optimize(v.n))
+	case *OneOrMore:
+		return NewOneOrMore(optimize(v.n))
+	case *All:
+		return optimizeAll(v.n)
+	case *AnyOf:
+		return optimizeAnyOf(v.n)
+	case *NoneOf:
+		ranges, ok := classRanges(v.n)
+		if !ok {
+			return v
+		}
+		if len(ranges) == 2 && ranges[0] == ranges[1] {
+			return NewNotRune(ranges[0])
+		}
+		return NewRangeTable(true, ranges...)
+	case *RangeTable:
+		if !v.negate && len(v.ranges) == 2 && v.ranges[0] == v.ranges[1] {
+			return NewRune(v.ranges[0])
+		}
+	}
+	return n
+}
+
+func optimizeAll(nodes []Node) Node {
+	subs := []Node{}
+	for _, nn := range nodes {
+		nn = optimize(nn)
+		if a, ok := nn.(*All); ok {
+			subs = append(subs, a.n...)
+		} else {
+			subs = append(subs, nn)
+		}
+	}
+	// Adjacent literal runes become a single string.
+	ret := []Node{}
+	for i := 0; i < len(subs); {
+		lit := literalPrefix(subs[i])
+		if lit == nil {
+			ret = append(ret, subs[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for ; j < len(subs); j++ {
+			l := literalPrefix(subs[j])
+			if l == nil {
+				break
+			}
+			lit = append(lit, l...)
+		}
+		ret = append(ret, literal(lit))
+		i = j
+	}
+	switch len(ret) {
+	case 0:
+		return NewAll()
+	case 1:
+		return ret[0]
+	}
+	return NewAll(ret...)
+}
+
+func optimizeAnyOf(nodes []Node) Node {
+	alts := []Node{}
+	for _, nn := range nodes {
+		nn = optimize(nn)
+		if a, ok := nn.(*AnyOf); ok {
+			alts = append(alts, a.n...)
+		} else {
+			alts = append(alts, nn)
+		}
+	}
+	alts = mergeClasses(factor(alts))
+	if len(alts) == 1 {
+		return alts[0]
+	}
+	return NewAnyOf(alts...)
+}
+
+// factor pulls the common literal prefix out of runs of adjacent
+// alternatives. Only adjacent alternatives are considered, as the order of
+// the alternatives decides which one is preferred.
+func factor(alts []Node) []Node {
+	ret := []Node{}
+	for i := 0; i < len(alts); {
+		common := leadingRunes(alts[i])
+		j := i + 1
+		for ; j < len(alts); j++ {
+			c := commonPrefix(common, leadingRunes(alts[j]))
+			if len(c) == 0 {
+				break
+			}
+			common = c
+		}
+		if j-i < 2 {
+			ret = append(ret, alts[i])
+			i++
+			continue
+		}
+		rests := []Node{}
+		for _, a := range alts[i:j] {
+			rests = append(rests, trimRunes(a, len(common)))
+		}
+		ret = append(ret, optimize(NewAll(literal(common), NewAnyOf(rests...))))
+		i = j
+	}
+	return ret
+}
+
+// mergeClasses turns runs of adjacent single-rune alternatives into range
+// tables. Each of them consumes exactly one rune, so their order does not
+// matter.
+func mergeClasses(alts []Node) []Node {
+	ret := []Node{}
+	for i := 0; i < len(alts); {
+		ranges, ok := classRanges(alts[i : i+1])
+		if !ok {
+			ret = append(ret, alts[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for ; j < len(alts); j++ {
+			r, ok := classRanges(alts[j : j+1])
+			if !ok {
+				break
+			}
+			ranges = append(ranges, r...)
+		}
+		if j-i < 2 {
+			ret = append(ret, alts[i])
+		} else {
+			ret = append(ret, optimize(NewRangeTable(false, ranges...)))
+		}
+		i = j
+	}
+	return ret
+}
+
+// classRanges returns the range pairs of nodes which each match a single
+// rune out of a set. If some node is something else, ok is false.
+func classRanges(nodes []Node) (ranges []rune, ok bool) {
+	for _, n := range nodes {
+		switch v := n.(type) {
+		case *Rune:
+			ranges = append(ranges, v.r, v.r)
+		case *RuneRange:
+			ranges = append(ranges, v.a, v.b)
+		case *RangeTable:
+			if v.negate {
+				return nil, false
+			}
+			ranges = append(ranges, v.ranges...)
+		case *Class:
+			ranges = append(ranges, v.Ranges()...)
+		default:
+			return nil, false
+		}
+	}
+	return ranges, true
+}
+
+// literalPrefix returns the runes of a literal node, or nil if n is not one.
+func literalPrefix(n Node) []rune {
+	switch v := n.(type) {
+	case *Rune:
+		return []rune{v.r}
+	case *String:
+		return append([]rune{}, v.r...)
+	}
+	return nil
+}
+
+// leadingRunes returns the literal runes which any match of n begins with.
+func leadingRunes(n Node) []rune {
+	if a, ok := n.(*All); ok {
+		if len(a.n) == 0 {
+			return nil
+		}
+		n = a.n[0]
+	}
+	return literalPrefix(n)
+}
+
+// trimRunes returns n without its first count leading literal runes.
+func trimRunes(n Node, count int) Node {
+	if a, ok := n.(*All); ok {
+		subs := append([]Node{trimRunes(a.n[0], count)}, a.n[1:]...)
+		return NewAll(subs...)
+	}
+	return literal(literalPrefix(n)[count:])
+}
+
+func commonPrefix(a, b []rune) []rune {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+// literal returns the simplest node which matches runes.
+func literal(runes []rune) Node {
+	switch len(runes) {
+	case 0:
+		return NewAll()
+	case 1:
+		return NewRune(runes[0])
+	}
+	return &String{r: runes}
+}
+
+// mergeRanges sorts inclusive range pairs and merges the ones which overlap
+// or are adjacent.
+func mergeRanges(ranges []rune) []rune {
+	pairs := make([][2]rune, 0, len(ranges)/2)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		pairs = append(pairs, [2]rune{ranges[i], ranges[i+1]})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0]
+	})
+	ret := []rune{}
+	for _, p := range pairs {
+		if n := len(ret); n > 0 && p[0] <= ret[n-1]+1 {
+			if p[1] > ret[n-1] {
+				ret[n-1] = p[1]
+			}
+			continue
+		}
+		ret = append(ret, p[0], p[1])
+	}
+	return ret
+}
@@ -0,0 +1,146 @@
+package match_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/susji/mre/input"
+	"github.com/susji/mre/match"
+)
+
+func root(n match.Node) *match.Root {
+	return match.NewRoot(match.NewCapture(n, 0)).(*match.Root)
+}
+
+func TestOptimize(t *testing.T) {
+	r := match.NewRune
+	type entry struct {
+		desc     string
+		n, exp   match.Node
+		matching []string
+	}
+
+	table := []entry{
+		{
+			desc:     "merge literals",
+			n:        match.NewAll(r('a'), match.NewAll(r('b'), r('c')), r('d')),
+			exp:      match.NewString("abcd"),
+			matching: []string{"abcd", "abc", "xabcdx"},
+		},
+		{
+			desc: "literals around a repetition",
+			n: match.NewAll(
+				r('a'), r('b'), match.NewOneOrMore(r('c')), r('d'), r('e')),
+			exp: match.NewAll(
+				match.NewString("ab"),
+				match.NewOneOrMore(r('c')),
+				match.NewString("de")),
+			matching: []string{"abcccde", "abde"},
+		},
+		{
+			desc:     "single member set",
+			n:        match.NewAnyOf(r('a')),
+			exp:      r('a'),
+			matching: []string{"a", "b"},
+		},
+		{
+			desc:     "single member inverse set",
+			n:        match.NewNoneOf(r('a')),
+			exp:      match.NewNotRune('a'),
+			matching: []string{"a", "b", ""},
+		},
+		{
+			desc:     "class in an alternation",
+			n:        match.NewAnyOf(match.NewClass('d'), r('x')),
+			exp:      match.NewRangeTable(false, '0', '9', 'x', 'x'),
+			matching: []string{"5", "x", "y"},
+		},
+		{
+			desc: "range table",
+			n: match.NewAnyOf(
+				match.NewRuneRange('d', 'f'), r('a'), r('e'),
+				match.NewRuneRange('b', 'c'), r('x')),
+			exp:      match.NewRangeTable(false, 'a', 'f', 'x', 'x'),
+			matching: []string{"a", "c", "f", "g", "x", "y", ""},
+		},
+		{
+			desc: "inverse range table",
+			n: match.NewNoneOf(
+				r('z'), match.NewRuneRange('a', 'c'), r('b')),
+			exp:      match.NewRangeTable(true, 'a', 'c', 'z', 'z'),
+			matching: []string{"a", "d", "z", "ä"},
+		},
+		{
+			desc: "factor prefix",
+			n: match.NewAnyOf(
+				match.NewAll(r('a'), r('b'), r('c')),
+				match.NewAll(r('a'), r('b'), r('d'))),
+			exp: match.NewAll(
+				match.NewString("ab"),
+				match.NewRangeTable(false, 'c', 'd')),
+			matching: []string{"abc", "abd", "abe", "xabdx"},
+		},
+		{
+			desc: "factor prefix of adjacent alternatives only",
+			n: match.NewAnyOf(
+				match.NewAll(r('a'), r('b')),
+				r('a'),
+				r('x'),
+				match.NewAll(r('a'), r('c'))),
+			exp: match.NewAnyOf(
+				match.NewAll(
+					r('a'),
+					match.NewAnyOf(r('b'), match.NewAll())),
+				r('x'),
+				match.NewString("ac")),
+			matching: []string{"ab", "a", "ac", "x"},
+		},
+		{
+			desc: "captures stay in place",
+			n: match.NewAnyOf(
+				match.NewCapture(match.NewAll(r('a'), r('b')), 1),
+				match.NewAll(r('a'), r('c'))),
+			exp: match.NewAnyOf(
+				match.NewCapture(match.NewString("ab"), 1),
+				match.NewString("ac")),
+			matching: []string{"ab", "ac"},
+		},
+		{
+			desc: "flatten alternations",
+			n: match.NewAnyOf(
+				match.NewAnyOf(match.NewString("xy"), r('a')),
+				match.NewAnyOf(r('b'), match.NewZeroOrMore(r('c')))),
+			exp: match.NewAnyOf(
+				match.NewString("xy"),
+				match.NewRangeTable(false, 'a', 'b'),
+				match.NewZeroOrMore(r('c'))),
+			matching: []string{"xy", "a", "b", "cc", ""},
+		},
+	}
+
+	for _, te := range table {
+		t.Run(te.desc, func(t *testing.T) {
+			orig := root(te.n)
+			opt := match.Optimize(orig)
+			if exp := root(te.exp); !reflect.DeepEqual(opt, exp) {
+				t.Error("not equal")
+				t.Log("wanted:\n", match.Dump(exp))
+				t.Log("got:\n", match.Dump(opt))
+			}
+			// Whatever the shape, the optimized tree must match like the
+			// original.
+			octx, ctx := opt.NewContext(), orig.NewContext()
+			for _, m := range te.matching {
+				in := input.String(m)
+				got, want := opt.Find(octx, in, 0), orig.Find(ctx, in, 0)
+				if got != want {
+					t.Errorf("%q: wanted %v, got %v", m, want, got)
+				} else if fmt.Sprint(octx.Slots()) != fmt.Sprint(ctx.Slots()) {
+					t.Errorf("%q: wanted slots %v, got %v",
+						m, ctx.Slots(), octx.Slots())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package match
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/susji/mre/ahocorasick"
+	"github.com/susji/mre/input"
+)
+
+// The literals found here let the matchers skip input which cannot take part
+// in a match. Invalid UTF-8 in the input is read as utf8.RuneError, which
+// does not show up when searching for the literal's UTF-8 encoding. For this
+// reason, the literals are cut at utf8.RuneError before using them.
+
+// literalRunes returns the runes of n if it only matches a literal. Otherwise,
+// ok is false.
+func literalRunes(n Node) (runes []rune, ok bool) {
+	switch v := n.(type) {
+	case *Rune:
+		runes = []rune{v.r}
+	case *String:
+		runes = v.r
+	case *Capture:
+		return literalRunes(v.n)
+	case *All:
+		for _, nn := range v.n {
+			r, ok := literalRunes(nn)
+			if !ok {
+				return nil, false
+			}
+			runes = append(runes, r...)
+		}
+	default:
+		return nil, false
+	}
+	return runes, true
+}
+
+// longest returns the longest part of s which can be searched for.
+func longest(s string) string {
+	best := ""
+	for _, part := range strings.Split(s, string(utf8.RuneError)) {
+		if len(part) > len(best) {
+			best = part
+		}
+	}
+	return best
+}
+
+// searchable returns the part of prefix p which can be searched for.
+func searchable(p string) string {
+	if i := strings.IndexRune(p, utf8.RuneError); i >= 0 {
+		return p[:i]
+	}
+	return p
+}
+
+// maxPrefixes limits how many alternative prefixes are collected.
+const maxPrefixes = 4096
+
+// prefixes returns literals one of which every match of n begins with. It is
+// nil if there are none. The literals may contain utf8.RuneError.
+func prefixes(n Node) []string {
+	if r, ok := literalRunes(n); ok {
+		return []string{string(r)}
+	}
+	switch v := n.(type) {
+	case *Capture:
+		return prefixes(v.n)
+	case *Atomic:
+		return prefixes(v.n)
+	case *OneOrMore:
+		return prefixes(v.n)
+	case *N:
+		if v.a > 0 {
+			return prefixes(v.n)
+		}
+	case *LengthRange:
+		if v.a > 0 {
+			return prefixes(v.n)
+		}
+	case *Lazy:
+		if v.a > 0 {
+			return prefixes(v.n)
+		}
+	case *AnyOf:
+		ret := []string{}
+		for _, alt := range v.n {
+			p := prefixes(alt)
+			if p == nil || len(ret)+len(p) > maxPrefixes {
+				return nil
+			}
+			ret = append(ret, p...)
+		}
+		return ret
+	case *All:
+		run := &strings.Builder{}
+		for _, nn := range v.n {
+			if r, ok := literalRunes(nn); ok {
+				run.WriteString(string(r))
+				continue
+			}
+			// Assertions do not consume anything.
+			if _, ok := nn.(*Assert); ok {
+				continue
+			}
+			// The literal run is followed by what nn begins with.
+			p := prefixes(nn)
+			if p == nil {
+				break
+			}
+			ret := make([]string, len(p))
+			for i := range p {
+				ret[i] = run.String() + p[i]
+			}
+			return ret
+		}
+		if run.Len() > 0 {
+			return []string{run.String()}
+		}
+	}
+	return nil
+}
+
+// Prefilter finds the positions of input where a match may begin.
+type Prefilter interface {
+	// Index returns the first such byte offset at or after from, or -1 if
+	// there is none.
+	Index(in input.Input, from int) int
+}
+
+type literalPrefilter string
+
+func (p literalPrefilter) Index(in input.Input, from int) int {
+	return in.Index(string(p), from)
+}
+
+// searchablePrefixes returns the searchable parts of lits without
+// duplicates, or nil if some literal has no such part.
+func searchablePrefixes(lits []string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+	for _, lit := range lits {
+		lit = searchable(lit)
+		if lit == "" {
+			return nil
+		}
+		if !seen[lit] {
+			seen[lit] = true
+			ret = append(ret, lit)
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// newPrefilter returns a prefilter which looks for lits. A single literal is
+// searched for with the input, and several at once with an Aho-Corasick
+// automaton. If there is nothing to look for, nil is returned.
+func newPrefilter(lits []string) Prefilter {
+	switch len(lits) {
+	case 0:
+		return nil
+	case 1:
+		return literalPrefilter(lits[0])
+	}
+	return ahocorasick.New(lits)
+}
+
+// required returns the longest literal which every match of n contains. It is
+// empty if there is none.
+func required(n Node) string {
+	if r, ok := literalRunes(n); ok {
+		return longest(string(r))
+	}
+	switch v := n.(type) {
+	case *Capture:
+		return required(v.n)
+	case *Exhaustive:
+		return required(v.n)
+	case *ScanTry:
+		return required(v.n)
+	case *OneOrMore:
+		return required(v.n)
+	case *Atomic:
+		return required(v.n)
+	case *N:
+		if v.a > 0 {
+			return required(v.n)
+		}
+	case *LengthRange:
+		if v.a > 0 {
+			return required(v.n)
+		}
+	case *Lazy:
+		if v.a > 0 {
+			return required(v.n)
+		}
+	case *All:
+		// Adjacent literal members form a single literal. Otherwise, we
+		// settle for the longest literal required by some member.
+		best, run := "", &strings.Builder{}
+		keep := func(s string) {
+			if s = longest(s); len(s) > len(best) {
+				best = s
+			}
+		}
+		for _, nn := range v.n {
+			if r, ok := literalRunes(nn); ok {
+				run.WriteString(string(r))
+				continue
+			}
+			if _, ok := nn.(*Assert); ok {
+				continue
+			}
+			// A literal run is followed by what all the prefixes of nn
+			// begin with.
+			p := prefixes(nn)
+			common := ""
+			if len(p) > 0 {
+				common = p[0]
+				for _, pp := range p[1:] {
+					common = string(commonPrefix([]rune(common), []rune(pp)))
+				}
+			}
+			keep(run.String() + common)
+			run.Reset()
+			keep(required(nn))
+		}
+		keep(run.String())
+		return best
+	}
+	return ""
+}
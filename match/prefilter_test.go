@@ -0,0 +1,57 @@
+package match_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/susji/mre/compile"
+	"github.com/susji/mre/lex"
+	"github.com/susji/mre/match"
+)
+
+func TestPrefilter(t *testing.T) {
+	type entry struct {
+		expr     string
+		prefixes []string
+		required string
+	}
+
+	table := []entry{
+		{"abc", []string{"abc"}, "abc"},
+		{"^abc", nil, "abc"},
+		{"ab+c", []string{"ab"}, "ab"},
+		{"x(ab)+y", []string{"xab"}, "xab"},
+		{"(foo|bar)baz", []string{"foo", "bar"}, "baz"},
+		{"(error|warning|fatal):", []string{"error", "warning", "fatal"}, ":"},
+		{"x(ab|a)c", []string{"xab", "xa"}, "xa"},
+		{"(ab|c*)d", nil, "d"},
+		{"[0-9]+-[a-z]-done", nil, "-done"},
+		{"a?bc", nil, "bc"},
+		{"(ab){2}c*", []string{"ab"}, "ab"},
+		{"ä+ö", []string{"ä"}, "ä"},
+		{"x*", nil, ""},
+		// U+FFFD also stands for invalid UTF-8 in the input, so it cannot
+		// be searched for as such.
+		{"a\uFFFDbc", []string{"a"}, "bc"},
+		{"\uFFFDbc|de", nil, ""},
+	}
+
+	for _, te := range table {
+		t.Run(te.expr, func(t *testing.T) {
+			root, err := compile.Compile(lex.Lex(te.expr))
+			if err != nil {
+				t.Fatal("errored: ", err)
+			}
+			root = match.Optimize(root)
+			if p := root.Prefixes(); !reflect.DeepEqual(p, te.prefixes) {
+				t.Errorf("wanted prefixes %q, got %q", te.prefixes, p)
+			}
+			if pf := root.Prefilter(); (pf == nil) != (te.prefixes == nil) {
+				t.Errorf("wanted prefilter for %q, got %v", te.prefixes, pf)
+			}
+			if r := root.Required(); r != te.required {
+				t.Errorf("wanted required %q, got %q", te.required, r)
+			}
+		})
+	}
+}
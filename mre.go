@@ -1,62 +1,223 @@
 package mre
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 
-
+	"github.com/susji/mre/ast"
 	"github.com/susji/mre/compile"
+	"github.com/susji/mre/dfa"
+	"github.com/susji/mre/input"
 	"github.com/susji/mre/lex"
 	"github.com/susji/mre/match"
+	"github.com/susji/mre/vm"
+)
+
+// Engine selects how a compiled expression is executed.
+type Engine uint8
 
+const (
+	// ENGINE_BACKTRACK walks the matcher tree and backtracks when needed.
+	ENGINE_BACKTRACK = Engine(iota)
+	// ENGINE_PIKEVM runs the expression as an NFA program with a Pike VM.
+	// Matching time is linear in the length of both the expression and the
+	// input.
+	ENGINE_PIKEVM
 )
 
+type CompileOptions struct {
+	Engine Engine
+	// Tracer, if not nil, follows the parser as it goes through the
+	// expression. See compile.NewWriterTracer.
+	Tracer compile.Tracer
+	// CaseInsensitive makes the expression match as if it began with (?i).
+	CaseInsensitive bool
+}
+
+// MRE is a compiled expression. It is safe for concurrent use by multiple
+// goroutines.
 type MRE struct {
-	expr string
-	root *match.Root
-	mctx *match.Context
+	expr   string
+	engine Engine
+	root   *match.Root
+	prog   *vm.Prog
+	// Every match begins where prefilter says it may and contains
+	// required. They let us skip input which cannot match without running
+	// an engine.
+	prefilter match.Prefilter
+	required  string
+	// names has the name of each capture by index. Unnamed captures have
+	// empty names.
+	names []string
+	// Matching state is not shared between concurrent matches. Instead,
+	// each match borrows its own from these pools.
+	mctxs sync.Pool
+	dfas  sync.Pool
 }
 
 func Compile(expr string) (*MRE, error) {
-	toks := lex.Lex(expr)
-	if toks.Count() == 0 {
-		return nil, fmt.Errorf("Nothing to compile.")
-	}
+	return CompileWith(expr, &CompileOptions{})
+}
 
+// CompileWith compiles expr with the given options. Nil options are the
+// same as the zero CompileOptions. If expr is malformed, the returned error
+// is a *SyntaxError.
+func CompileWith(expr string, opts *CompileOptions) (*MRE, error) {
+	if opts == nil {
+		opts = &CompileOptions{}
+	}
 	m := &MRE{}
-	mctx, root, err := compile.Compile(toks)
+	n, err := parse(expr, &compile.Options{
+		Tracer:          opts.Tracer,
+		CaseInsensitive: opts.CaseInsensitive,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Compiling failed: %w", err)
+		return nil, err
+	}
+	root := match.Optimize(compile.Lower(n))
+	// The DFA is built out of the program, so we want one regardless of the
+	// engine. Only the Pike VM engine insists on having it, though.
+	prog, err := vm.Compile(root)
+	switch opts.Engine {
+	case ENGINE_BACKTRACK:
+	case ENGINE_PIKEVM:
+		if err != nil {
+			return nil, fmt.Errorf("Compiling failed: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown engine: %d", opts.Engine)
+	}
+	if err == nil {
+		m.prog = prog
+		m.dfas.New = func() interface{} {
+			return dfa.New(prog, dfa.DEFAULT_MAX_STATES)
+		}
 	}
-	m.mctx = mctx
+	m.mctxs.New = func() interface{} {
+		return root.NewContext()
+	}
+	m.engine = opts.Engine
 	m.root = root
+	m.prefilter = root.Prefilter()
+	m.required = root.Required()
 	m.expr = expr
+	m.names = subexpNames(n)
 	return m, nil
 }
 
-func (m *MRE) Match(what string) bool {
-	m.mctx.Reset()
-	_, _, err := m.root.Match(m.mctx, []rune(what))
+// subexpNames returns the names of the captures of the syntax tree n by
+// index.
+func subexpNames(n ast.Node) []string {
+	names := []string{""}
+	ast.Walk(n, func(n ast.Node) bool {
+		if g, ok := n.(*ast.Group); ok && g.Index > 0 {
+			for len(names) <= g.Index {
+				names = append(names, "")
+			}
+			names[g.Index] = g.Name
+		}
+		return true
+	})
+	return names
+}
+
+// SubexpNames returns the names of the subexpressions by index. The name of
+// the whole expression at index zero and the names of unnamed
+// subexpressions are empty. The slice should not be modified.
+func (m *MRE) SubexpNames() []string {
+	return m.names
+}
+
+// SubexpIndex returns the index of the subexpression with the given name, or
+// -1 if there is none.
+func (m *MRE) SubexpIndex(name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, n := range m.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parse returns the syntax tree of expr without compiling it. If expr is
+// malformed, the returned error is a *SyntaxError.
+func Parse(expr string) (ast.Node, error) {
+	return parse(expr, &compile.Options{})
+}
+
+func parse(expr string, opts *compile.Options) (ast.Node, error) {
+	n, err := compile.Parse(lex.Lex(expr), opts)
 	if err != nil {
+		var serr *compile.Error
+		if errors.As(err, &serr) {
+			return nil, newSyntaxError(expr, serr)
+		}
+		return nil, fmt.Errorf("Parsing failed: %w", err)
+	}
+	return n, nil
+}
+
+// Match tells if what matches. When possible, the answer is given by a DFA
+// which does not bother with captures.
+func (m *MRE) Match(what string) bool {
+	return m.match(input.String(what))
+}
+
+// MatchBytes is like Match, but for a byte slice.
+func (m *MRE) MatchBytes(b []byte) bool {
+	return m.match(input.Bytes(b))
+}
+
+func (m *MRE) match(in input.Input) bool {
+	if m.required != "" && in.Index(m.required, 0) < 0 {
 		return false
 	}
-	return true
+	if m.prog != nil {
+		d := m.dfas.Get().(*dfa.DFA)
+		matched, err := d.Match(in)
+		m.dfas.Put(d)
+		if err == nil {
+			return matched
+		}
+	}
+	return m.find(in, 0) != nil
 }
 
-func (m *MRE) Dump() string {
-	if m.root == nil {
-		panic("No matcher.")
+// find looks for the preferred match in in starting from byte offset at and
+// returns its capture positions, or nil if there is no match.
+func (m *MRE) find(in input.Input, at int) []int {
+	if m.engine == ENGINE_PIKEVM {
+		if m.required != "" && in.Index(m.required, at) < 0 {
+			return nil
+		}
+		// The leftmost match cannot begin before the first position
+		// the prefilter gives.
+		if m.prefilter != nil {
+			if at = m.prefilter.Index(in, at); at < 0 {
+				return nil
+			}
+		}
+		return m.prog.MatchAt(in, at)
 	}
-	return match.Dump(m.root)
+	// The matcher tree does its own prefiltering.
+	mctx := m.mctxs.Get().(*match.Context)
+	defer m.mctxs.Put(mctx)
+	if !m.root.Find(mctx, in, at) {
+		return nil
+	}
+	return append([]int{}, mctx.Slots()...)
 }
 
-func (m *MRE) Captures() []string {
+func (m *MRE) Dump() string {
 	if m.root == nil {
 		panic("No matcher.")
 	}
-	res := m.mctx.Captures()
-	ret := []string{}
-	for _, sr := range res {
-		ret = append(ret, string(sr))
+	if m.engine == ENGINE_PIKEVM {
+		return match.Dump(m.root) + m.prog.String()
 	}
-	return ret
+	return match.Dump(m.root)
 }
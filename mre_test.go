@@ -2,12 +2,20 @@ package mre_test
 
 import (
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/susji/mre"
 )
 
+var engines = map[string]mre.Engine{
+	"backtrack": mre.ENGINE_BACKTRACK,
+	"pikevm":    mre.ENGINE_PIKEVM,
+}
+
 func TestBasic(t *testing.T) {
 	type entry struct {
 		expr, desc string
@@ -17,36 +25,50 @@ func TestBasic(t *testing.T) {
 	table := []entry{
 		{".", "match any one rune", []string{"z", "yz"}, []string{""}},
 		{"^a", "match 'a' rune", []string{"a", "aZ"}, []string{"b", "ba", ""}},
+		{"^a*ab$", "give back repetition", []string{"ab", "aaab"},
+			[]string{"aaa", "b", "aaba"}},
+		{"^(a|ab)c$", "retry alternation", []string{"ac", "abc"},
+			[]string{"abbc", "a"}},
+		{"^[^ab]$", "inverse set matches one rune", []string{"c", "z"},
+			[]string{"a", "b", "cd", ""}},
+		{"^a{2,}$", "unbounded length range", []string{"aa", "aaaaa"},
+			[]string{"a", "aab"}},
+		{"x*", "match empty", []string{"", "abc", "xx"}, []string{}},
+		{"([0-9]+)0$", "backtrack into capture", []string{"100", "a10"},
+			[]string{"0", "101"}},
 	}
 
 	for _, te := range table {
-		t.Run(te.desc, func(t *testing.T) {
-			m, err := mre.Compile(te.expr)
-			if err != nil {
-				t.Error("compile failed: ", err)
-			} else if m == nil {
-				t.Errorf("compiled without error but nil matcher")
-				return
-			}
+		for en, e := range engines {
+			t.Run(en+"_"+te.desc, func(t *testing.T) {
+				m, err := mre.CompileWith(
+					te.expr, &mre.CompileOptions{Engine: e})
+				if err != nil {
+					t.Error("compile failed: ", err)
+				} else if m == nil {
+					t.Errorf("compiled without error but nil matcher")
+					return
+				}
 
-			for _, y := range te.yes {
-				t.Run("should_match_"+y, func(t *testing.T) {
-					res := m.Match(y)
-					if !res {
-						t.Errorf("no match")
-					}
-				})
-			}
+				for _, y := range te.yes {
+					t.Run("should_match_"+y, func(t *testing.T) {
+						res := m.Match(y)
+						if !res {
+							t.Errorf("no match")
+						}
+					})
+				}
 
-			for _, n := range te.no {
-				t.Run("should_not_match_"+n, func(t *testing.T) {
-					res := m.Match(n)
-					if res {
-						t.Errorf("match")
-					}
-				})
-			}
-		})
+				for _, n := range te.no {
+					t.Run("should_not_match_"+n, func(t *testing.T) {
+						res := m.Match(n)
+						if res {
+							t.Errorf("match")
+						}
+					})
+				}
+			})
+		}
 	}
 }
 
@@ -59,7 +81,7 @@ func TestCapture(t *testing.T) {
 	if !matched {
 		t.Error("matching failed")
 	}
-	res := m.Captures()
+	res := m.FindStringSubmatch("21-434")
 	exp := []string{
 		"21-434",
 		"21",
@@ -72,6 +94,48 @@ func TestCapture(t *testing.T) {
 	}
 }
 
+func TestCaptureBacktrack(t *testing.T) {
+	m, err := mre.Compile("^([a-z]*)([a-z]{2})$")
+	if err != nil {
+		t.Fatal("compile failed: ", err)
+	}
+	if !m.Match("abcde") {
+		t.Fatal("matching failed")
+	}
+	res := m.FindStringSubmatch("abcde")
+	exp := []string{"abcde", "abc", "de"}
+	if !reflect.DeepEqual(res, exp) {
+		t.Errorf("wanted %#v, got %#v", exp, res)
+	}
+}
+
+func TestCompileWithNilOptions(t *testing.T) {
+	m, err := mre.CompileWith("^a+$", nil)
+	if err != nil {
+		t.Fatal("compile failed: ", err)
+	}
+	if !m.Match("aaa") || m.Match("aab") {
+		t.Error("matching failed")
+	}
+}
+
+func TestCapturePikeVM(t *testing.T) {
+	m, err := mre.CompileWith(
+		"^(([0-9]+)\\.){2}([0-9]+)$",
+		&mre.CompileOptions{Engine: mre.ENGINE_PIKEVM})
+	if err != nil {
+		t.Fatal("compile failed: ", err)
+	}
+	if !m.Match("10.200.3") {
+		t.Fatal("matching failed")
+	}
+	res := m.FindStringSubmatch("10.200.3")
+	exp := []string{"10.200.3", "200.", "200", "3"}
+	if !reflect.DeepEqual(res, exp) {
+		t.Errorf("wanted %#v, got %#v", exp, res)
+	}
+}
+
 func TestIpv4(t *testing.T) {
 	re := `
 ^(
@@ -112,16 +176,14 @@ $`
 			if !matched {
 				t.Fatal("matching failed")
 			}
-			captures := m.Captures()
-			// First three components are in the second capture. Get rid of the
-			// last dot manually.
-			got := strings.SplitN(captures[1][:], ".", 3)
-			got[2] = got[2][:len(got[2])-1]
-			got = append(got, captures[3])
-			want := strings.Split(v, ".")
-			if !reflect.DeepEqual(got, want) {
+			captures := m.FindStringSubmatch(v)
+			// The repeated capture holds the last of the first three
+			// components with its dot.
+			c := strings.Split(v, ".")
+			want := []string{v, c[2] + ".", c[2], c[3]}
+			if !reflect.DeepEqual(captures, want) {
 				t.Errorf("component mismatch, wanted %#v, got %#v",
-					want, got)
+					want, captures)
 			}
 		})
 	}
@@ -134,3 +196,194 @@ $`
 		})
 	}
 }
+
+func TestConcurrent(t *testing.T) {
+	// This is mostly useful with the race detector, that is, `go test -race'.
+	for _, e := range []mre.Engine{mre.ENGINE_BACKTRACK, mre.ENGINE_PIKEVM} {
+		m, err := mre.CompileWith(
+			"^([a-z]+)-([0-9]+)$", &mre.CompileOptions{Engine: e})
+		if err != nil {
+			t.Fatal("compile failed: ", err)
+		}
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					word := strings.Repeat(string(rune('a'+g)), i%5+1)
+					num := strconv.Itoa(g*1000 + i)
+					what := word + "-" + num
+					if !m.Match(what) {
+						t.Errorf("%q did not match", what)
+					}
+					if m.Match(what + "!") {
+						t.Errorf("%q matched", what+"!")
+					}
+					exp := []string{what, word, num}
+					if got := m.FindStringSubmatch(what); !reflect.DeepEqual(got, exp) {
+						t.Errorf("wanted %#v, got %#v", exp, got)
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	exprs := []string{"error: (\\w+)", "[a-f]+", "^straße$", "ǅ"}
+	tests := []string{"ERROR: Disk", "xABCfg", "STRASSE", "STRAẞE", "ǆ"}
+	for en, e := range engines {
+		for _, expr := range exprs {
+			m, err := mre.CompileWith(expr, &mre.CompileOptions{
+				Engine:          e,
+				CaseInsensitive: true,
+			})
+			if err != nil {
+				t.Fatal("compile failed: ", err)
+			}
+			std := regexp.MustCompile("(?i)" + expr)
+			for _, test := range tests {
+				got := m.FindStringSubmatch(test)
+				if want := std.FindStringSubmatch(test); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: %q in %q: wanted %q, got %q",
+						en, expr, test, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestSubexpNames(t *testing.T) {
+	exprs := []string{
+		`(?P<year>\d+)-(\d+)-(?<day>\d+)`,
+		`(?P<a>x(?P<b>y)?)(?:z)`,
+		`x`,
+	}
+	tests := []string{"2024-05-17", "xz", "xyz", "x", ""}
+	for en, e := range engines {
+		for _, expr := range exprs {
+			m, err := mre.CompileWith(expr, &mre.CompileOptions{Engine: e})
+			if err != nil {
+				t.Fatal("compile failed: ", err)
+			}
+			std := regexp.MustCompile(expr)
+			if got, want := m.SubexpNames(), std.SubexpNames(); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %q: wanted names %q, got %q", en, expr, want, got)
+			}
+			for _, name := range append(std.SubexpNames(), "nope") {
+				if got, want := m.SubexpIndex(name), std.SubexpIndex(name); got != want {
+					t.Errorf("%s: %q: wanted index %d for %q, got %d",
+						en, expr, want, name, got)
+				}
+			}
+			for _, test := range tests {
+				var want map[string]string
+				if sub := std.FindStringSubmatch(test); sub != nil {
+					want = map[string]string{}
+					for i, name := range std.SubexpNames() {
+						if name != "" {
+							want[name] = sub[i]
+						}
+					}
+				}
+				if got := m.FindStringSubmatchMap(test); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: %q in %q: wanted %q, got %q",
+						en, expr, test, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestPossessive(t *testing.T) {
+	type entry struct {
+		expr, test string
+		exp        []string
+	}
+	table := []entry{
+		{`a*+a`, "aaa", nil},
+		{`a++b`, "aaab", []string{"aaab"}},
+		{`"[^"]*+"`, `x "ab" y`, []string{`"ab"`}},
+		{`a?+a`, "a", nil},
+		{`a?+a`, "aa", []string{"aa"}},
+		{`a{1,3}+a`, "aaaa", []string{"aaaa"}},
+		{`a{1,3}+a`, "aaa", nil},
+		{`(?U)a*+a`, "aa", nil},
+		{`x(?>a*)a`, "xaa", nil},
+		{`(?>a|ab)c`, "abc", nil},
+		{`(?>a|ab)c`, "ac", []string{"ac"}},
+		{`(?>(a+))b`, "aab", []string{"aab", "aa"}},
+		{`(?>(a)|b)+c`, "abac", []string{"abac", "a"}},
+		// What the atomic group captured is undone when the rest fails.
+		{`(?>(a))x|a(b)`, "ab", []string{"ab", "", "b"}},
+	}
+	for _, te := range table {
+		m, err := mre.CompileWith(
+			te.expr, &mre.CompileOptions{Engine: mre.ENGINE_BACKTRACK})
+		if err != nil {
+			t.Fatal("compile failed: ", err)
+		}
+		if got := m.FindStringSubmatch(te.test); !reflect.DeepEqual(got, te.exp) {
+			t.Errorf("%q in %q: wanted %q, got %q", te.expr, te.test, te.exp, got)
+		}
+		if got, want := m.Match(te.test), te.exp != nil; got != want {
+			t.Errorf("%q in %q: wanted Match %t, got %t",
+				te.expr, te.test, want, got)
+		}
+		// The Pike VM has no way of giving up on the less preferred
+		// threads.
+		_, err = mre.CompileWith(
+			te.expr, &mre.CompileOptions{Engine: mre.ENGINE_PIKEVM})
+		if err == nil {
+			t.Errorf("%q: wanted an error from the Pike VM", te.expr)
+		}
+	}
+}
+
+func TestLongInput(t *testing.T) {
+	// Matching must not need Go stack in proportion to the input.
+	n := 2 << 20
+	text := strings.Repeat("a", n) + "x"
+	table := []struct {
+		expr string
+		exp  []int
+	}{
+		{`(a|b)*x`, []int{0, n + 1, n - 1, n}},
+		{`a*x`, []int{0, n + 1}},
+		{`(a|b)*?x`, []int{0, n + 1, n - 1, n}},
+	}
+	for _, te := range table {
+		for en, e := range engines {
+			m, err := mre.CompileWith(te.expr, &mre.CompileOptions{Engine: e})
+			if err != nil {
+				t.Fatal("compile failed: ", err)
+			}
+			if got := m.FindStringSubmatchIndex(text); !reflect.DeepEqual(got, te.exp) {
+				t.Errorf("%s: %q: wanted %v, got %v", en, te.expr, te.exp, got)
+			}
+		}
+	}
+}
+
+func TestLargeRepeat(t *testing.T) {
+	// The repetitions are too large to unroll for the Pike VM, but the
+	// backtracker does not need to.
+	expr := `^(a{1000}){1000}$`
+	if _, err := mre.CompileWith(
+		expr, &mre.CompileOptions{Engine: mre.ENGINE_PIKEVM}); err == nil {
+		t.Error("Pike VM compile should fail")
+	}
+	m, err := mre.CompileWith(
+		expr, &mre.CompileOptions{Engine: mre.ENGINE_BACKTRACK})
+	if err != nil {
+		t.Fatal("compile failed: ", err)
+	}
+	if !m.Match(strings.Repeat("a", 1000*1000)) {
+		t.Error("should match")
+	}
+	if m.Match(strings.Repeat("a", 1000*1000-1)) {
+		t.Error("should not match")
+	}
+}
@@ -0,0 +1,128 @@
+package mre
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/susji/mre/input"
+)
+
+// replaceAll builds a copy of src in which each match is replaced with what
+// repl appends to the buffer given to it.
+func (m *MRE) replaceAll(src string, repl func([]byte, []int) []byte) []byte {
+	var buf []byte
+	last := 0
+	m.all(input.String(src), -1, func(slots []int) {
+		buf = append(buf, src[last:slots[0]]...)
+		buf = repl(buf, slots)
+		last = slots[1]
+	})
+	return append(buf, src[last:]...)
+}
+
+// ReplaceAllString returns a copy of src in which matches are replaced with
+// repl. Inside repl, $ signs are interpreted as in Expand.
+func (m *MRE) ReplaceAllString(src, repl string) string {
+	return string(m.replaceAll(src, func(dst []byte, slots []int) []byte {
+		return m.expand(dst, repl, src, slots)
+	}))
+}
+
+// ReplaceAllLiteralString returns a copy of src in which matches are replaced
+// with repl as it is.
+func (m *MRE) ReplaceAllLiteralString(src, repl string) string {
+	return string(m.replaceAll(src, func(dst []byte, slots []int) []byte {
+		return append(dst, repl...)
+	}))
+}
+
+// ReplaceAllStringFunc returns a copy of src in which matches are replaced
+// with what repl returns for the matched text.
+func (m *MRE) ReplaceAllStringFunc(src string, repl func(string) string) string {
+	return string(m.replaceAll(src, func(dst []byte, slots []int) []byte {
+		return append(dst, repl(src[slots[0]:slots[1]])...)
+	}))
+}
+
+// Expand appends template to dst with its variables replaced by the matches
+// of the corresponding subexpressions in src. The match positions are the
+// ones returned by FindSubmatchIndex.
+//
+// A variable is either $name or ${name}, where name is a non-empty sequence
+// of letters, digits and underscores. A purely numeric name refers to the
+// subexpression with that index, and other names to named subexpressions.
+// Names which do not refer to a subexpression expand to nothing. Use $$ for a
+// literal $.
+func (m *MRE) Expand(dst []byte, template []byte, src []byte, match []int) []byte {
+	return m.expand(dst, string(template), string(src), match)
+}
+
+// ExpandString is like Expand, but for strings.
+func (m *MRE) ExpandString(dst []byte, template string, src string, match []int) []byte {
+	return m.expand(dst, template, src, match)
+}
+
+func (m *MRE) expand(dst []byte, template, src string, match []int) []byte {
+	for len(template) > 0 {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			break
+		}
+		dst = append(dst, template[:i]...)
+		template = template[i:]
+		if len(template) > 1 && template[1] == '$' {
+			dst = append(dst, '$')
+			template = template[2:]
+			continue
+		}
+		name, rest, ok := variable(template)
+		if !ok {
+			// Not a variable, so the $ stays as it is.
+			dst = append(dst, '$')
+			template = template[1:]
+			continue
+		}
+		template = rest
+		n, err := strconv.Atoi(name)
+		if err != nil {
+			n = m.SubexpIndex(name)
+		}
+		if n >= 0 && 2*n+1 < len(match) && match[2*n] >= 0 {
+			dst = append(dst, src[match[2*n]:match[2*n+1]]...)
+		}
+	}
+	return append(dst, template...)
+}
+
+// variable parses a $name or ${name} from the beginning of template.
+func variable(template string) (name, rest string, ok bool) {
+	if len(template) < 2 || template[0] != '$' {
+		return "", "", false
+	}
+	brace := template[1] == '{'
+	i := 1
+	if brace {
+		i++
+	}
+	start := i
+	for i < len(template) {
+		r, w := utf8.DecodeRuneInString(template[i:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			break
+		}
+		i += w
+	}
+	if i == start {
+		return "", "", false
+	}
+	name = template[start:i]
+	if brace {
+		if i >= len(template) || template[i] != '}' {
+			return "", "", false
+		}
+		i++
+	}
+	return name, template[i:], true
+}
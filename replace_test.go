@@ -0,0 +1,71 @@
+package mre_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/susji/mre"
+)
+
+func TestReplace(t *testing.T) {
+	type entry struct {
+		expr, test, repl string
+	}
+
+	table := []entry{
+		{"a", "banana", "o"},
+		{"x*", "banana", "-"},
+		{"a*", "baaanaa", "<$0>"},
+		{"([0-9]+)-([a-z]*)", "12-ab 3- 45-c", "$2:$1"},
+		{"([0-9]+)-([a-z]*)", "12-ab 3- 45-c", "${1}x$1x"},
+		{"(a)(n)?", "banana", "[$2|$1]"},
+		{"(a)", "banana", "$$1 $ ${ ${1 ${name} $9"},
+		{"ö+", "äöö öäö", "o"},
+		{"^$", "", "empty"},
+		{"b$", "bb", "${0}${0}"},
+		{`(?P<first>\w+) (?P<last>\w+)`, "ada lovelace", "${last}, $first"},
+		{`(?P<first>\w+) (\w+)`, "ada lovelace", "$first_x ${2}${first}"},
+		{`(?<x>a)|(?<y>b)`, "abc", "[$x$y]"},
+	}
+
+	for _, te := range table {
+		std := regexp.MustCompile(te.expr)
+		for en, e := range engines {
+			t.Run(en+"_"+te.expr+"_"+te.test+"_"+te.repl, func(t *testing.T) {
+				m, err := mre.CompileWith(
+					te.expr, &mre.CompileOptions{Engine: e})
+				if err != nil {
+					t.Fatal("compile failed: ", err)
+				}
+				check := func(what, got, want string) {
+					if got != want {
+						t.Errorf("%s: wanted %q, got %q", what, want, got)
+					}
+				}
+				check("ReplaceAllString",
+					m.ReplaceAllString(te.test, te.repl),
+					std.ReplaceAllString(te.test, te.repl))
+				check("ReplaceAllLiteralString",
+					m.ReplaceAllLiteralString(te.test, te.repl),
+					std.ReplaceAllLiteralString(te.test, te.repl))
+				check("ReplaceAllStringFunc",
+					m.ReplaceAllStringFunc(te.test, strings.ToUpper),
+					std.ReplaceAllStringFunc(te.test, strings.ToUpper))
+
+				var got, want []byte
+				for _, loc := range m.FindAllStringSubmatchIndex(te.test, -1) {
+					got = m.ExpandString(got, te.repl, te.test, loc)
+					want = std.ExpandString(want, te.repl, te.test, loc)
+				}
+				check("ExpandString", string(got), string(want))
+				got, want = nil, nil
+				for _, loc := range m.FindAllSubmatchIndex([]byte(te.test), -1) {
+					got = m.Expand(got, []byte(te.repl), []byte(te.test), loc)
+					want = std.Expand(want, []byte(te.repl), []byte(te.test), loc)
+				}
+				check("Expand", string(got), string(want))
+			})
+		}
+	}
+}
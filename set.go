@@ -0,0 +1,79 @@
+package mre
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/susji/mre/compile"
+	"github.com/susji/mre/dfa"
+	"github.com/susji/mre/input"
+	"github.com/susji/mre/match"
+	"github.com/susji/mre/vm"
+)
+
+// Set is a group of expressions compiled into a single program. It tells
+// which of the expressions match an input by scanning the input only once.
+// It is safe for concurrent use by multiple goroutines.
+type Set struct {
+	exprs []string
+	prog  *vm.Prog
+	dfas  sync.Pool
+}
+
+// CompileSet compiles exprs into a set. If one of them is malformed, the
+// returned error is a *SyntaxError. The set runs on the Pike VM, so like with
+// ENGINE_PIKEVM, expressions which it cannot run, such as ones with
+// possessive repetitions, atomic groups or too large programs, are refused
+// with a different error.
+func CompileSet(exprs []string) (*Set, error) {
+	s := &Set{exprs: append([]string{}, exprs...)}
+	if len(exprs) == 0 {
+		return s, nil
+	}
+	roots := []*match.Root{}
+	for _, expr := range exprs {
+		n, err := parse(expr, &compile.Options{})
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, match.Optimize(compile.Lower(n)))
+	}
+	prog, err := vm.CompileSet(roots)
+	if err != nil {
+		return nil, fmt.Errorf("Compiling failed: %w", err)
+	}
+	s.prog = prog
+	s.dfas.New = func() interface{} {
+		return dfa.New(prog, dfa.DEFAULT_MAX_STATES)
+	}
+	return s, nil
+}
+
+// Len returns the number of expressions in the set.
+func (s *Set) Len() int {
+	return len(s.exprs)
+}
+
+// Matches returns the sorted indices of the expressions which match what, or
+// nil if none does.
+func (s *Set) Matches(what string) []int {
+	return s.matches(input.String(what))
+}
+
+// MatchesBytes is like Matches, but for a byte slice.
+func (s *Set) MatchesBytes(b []byte) []int {
+	return s.matches(input.Bytes(b))
+}
+
+func (s *Set) matches(in input.Input) []int {
+	if s.prog == nil {
+		return nil
+	}
+	d := s.dfas.Get().(*dfa.DFA)
+	matched, err := d.MatchSet(in)
+	s.dfas.Put(d)
+	if err == nil {
+		return matched
+	}
+	return s.prog.MatchSet(in)
+}
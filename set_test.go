@@ -0,0 +1,105 @@
+package mre_test
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/susji/mre"
+)
+
+func TestSet(t *testing.T) {
+	exprs := []string{
+		"^GET ",
+		"error|fatal",
+		"[0-9]{3}$",
+		"timeout",
+		"^$",
+		"(a|ab)(c|bcd)",
+	}
+	s, err := mre.CompileSet(exprs)
+	if err != nil {
+		t.Fatal("compile failed: ", err)
+	}
+	if s.Len() != len(exprs) {
+		t.Errorf("wanted %d expressions, got %d", len(exprs), s.Len())
+	}
+	ms := []*mre.MRE{}
+	for _, expr := range exprs {
+		m, err := mre.Compile(expr)
+		if err != nil {
+			t.Fatal("compile failed: ", err)
+		}
+		ms = append(ms, m)
+	}
+	tests := []string{
+		"",
+		"GET / 200",
+		"GET / 500 fatal timeout",
+		"POST /error",
+		"abcd 404",
+		"no match here",
+		"\xffGET 123",
+	}
+	for _, test := range tests {
+		var exp []int
+		for i, m := range ms {
+			if m.Match(test) {
+				exp = append(exp, i)
+			}
+		}
+		if got := s.Matches(test); !reflect.DeepEqual(got, exp) {
+			t.Errorf("%q: wanted %v, got %v", test, exp, got)
+		}
+		if got := s.MatchesBytes([]byte(test)); !reflect.DeepEqual(got, exp) {
+			t.Errorf("%q as bytes: wanted %v, got %v", test, exp, got)
+		}
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	exprs := []string{}
+	for i := 0; i < 300; i++ {
+		exprs = append(exprs, "id="+strconv.Itoa(i)+"[^0-9]")
+	}
+	s, err := mre.CompileSet(exprs)
+	if err != nil {
+		t.Fatal("compile failed: ", err)
+	}
+	got := s.Matches("a id=7 b id=123; c id=299")
+	if exp := []int{7, 123}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("wanted %v, got %v", exp, got)
+	}
+}
+
+func TestSetEmpty(t *testing.T) {
+	s, err := mre.CompileSet(nil)
+	if err != nil {
+		t.Fatal("compile failed: ", err)
+	}
+	if got := s.Matches("abc"); got != nil {
+		t.Errorf("wanted no matches, got %v", got)
+	}
+}
+
+func TestSetSyntaxError(t *testing.T) {
+	_, err := mre.CompileSet([]string{"a", "(b"})
+	var serr *mre.SyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatalf("wanted a syntax error, got %v", err)
+	}
+	if serr.Expr != "(b" {
+		t.Errorf("wanted the error in %q, got %q", "(b", serr.Expr)
+	}
+}
+
+func TestSetUnsupported(t *testing.T) {
+	for _, expr := range []string{"a*+b", "(?>a*)b", "(a{1000}){1000}"} {
+		_, err := mre.CompileSet([]string{"a", expr})
+		var serr *mre.SyntaxError
+		if err == nil || errors.As(err, &serr) {
+			t.Errorf("%q: wanted a compiling error, got %v", expr, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package mre
+
+// Split slices s into substrings separated by the matches and returns them.
+// Like in Go's `regexp', n limits the number of substrings: if n is
+// positive, at most n substrings are returned and the last one holds the
+// unsplit remainder. If n is zero, nil is returned, and if n is negative, all
+// substrings are returned.
+func (m *MRE) Split(s string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	if len(m.expr) > 0 && len(s) == 0 {
+		return []string{""}
+	}
+	ret := []string{}
+	beg, end := 0, 0
+	for _, loc := range m.FindAllStringIndex(s, n) {
+		if n > 0 && len(ret) == n-1 {
+			break
+		}
+		end = loc[0]
+		// An empty match at the very beginning does not split anything.
+		if loc[1] != 0 {
+			ret = append(ret, s[beg:end])
+		}
+		beg = loc[1]
+	}
+	if end != len(s) {
+		ret = append(ret, s[beg:])
+	}
+	return ret
+}
@@ -0,0 +1,46 @@
+package mre_test
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/susji/mre"
+)
+
+func TestSplit(t *testing.T) {
+	table := []struct {
+		expr, test string
+	}{
+		{";", "a;b;c"},
+		{";", ""},
+		{";", ";a;;b;"},
+		{"x*", "banana"},
+		{"a*", "baaanaa"},
+		{"[0-9]+", "1ab23cd456"},
+		{"ö", "äöäöä"},
+		{"^", "abc"},
+		{"$", "abc"},
+		{"b", "bbb"},
+	}
+
+	for _, te := range table {
+		std := regexp.MustCompile(te.expr)
+		for en, e := range engines {
+			t.Run(en+"_"+te.expr+"_"+te.test, func(t *testing.T) {
+				m, err := mre.CompileWith(
+					te.expr, &mre.CompileOptions{Engine: e})
+				if err != nil {
+					t.Fatal("compile failed: ", err)
+				}
+				for _, n := range []int{-1, 0, 1, 2, 3, 10} {
+					got := m.Split(te.test, n)
+					want := std.Split(te.test, n)
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("n=%d: wanted %#v, got %#v", n, want, got)
+					}
+				}
+			})
+		}
+	}
+}
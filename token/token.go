@@ -23,6 +23,14 @@ const (
 	TOK_PIPE
 	TOK_DIGIT
 	TOK_RUNE
+	// TOK_CLASS is a shorthand class such as \d. Its rune is the letter
+	// after the backslash.
+	TOK_CLASS
+	// TOK_INVALID is a malformed escape sequence.
+	TOK_INVALID
+	// TOK_PROPERTY is a Unicode class such as \p{Greek}. Its rune is 'p' or
+	// 'P' and its text is the name within the braces.
+	TOK_PROPERTY
 )
 
 var KindNames = []string{
@@ -43,6 +51,9 @@ var KindNames = []string{
 	"|",
 	"D",
 	"R",
+	"C",
+	"!",
+	"P",
 }
 
 type TokenKind uint8
@@ -51,11 +62,15 @@ type TokenKind uint8
 type Token struct {
 	kind   TokenKind
 	column uint
+	width  uint
 	ru     rune
+	text   string
 }
 
 type Tokens struct {
 	toks []*Token
+	prev *Token
+	end  uint
 }
 
 func (t *Token) Kind() TokenKind {
@@ -72,6 +87,10 @@ func (t *Token) String() string {
 		return fmt.Sprintf("'%c'", t.Rune())
 	case TOK_DIGIT:
 		return fmt.Sprintf("<%c>", t.Rune())
+	case TOK_CLASS:
+		return fmt.Sprintf("\\%c", t.Rune())
+	case TOK_PROPERTY:
+		return fmt.Sprintf("\\%c{%s}", t.Rune(), t.Text())
 	default:
 		return t.Name()
 	}
@@ -81,12 +100,42 @@ func (t *Token) Column() uint {
 	return t.column
 }
 
+// Width is the number of runes the token spans in the expression. Escaped
+// runes, for example, span more than one.
+func (t *Token) Width() uint {
+	return t.width
+}
+
 func (t *Token) Rune() rune {
 	return t.ru
 }
 
+// Text is the name of a TOK_PROPERTY token.
+func (t *Token) Text() string {
+	return t.text
+}
+
 func (t *Tokens) Push(kind TokenKind, column uint, ru rune) {
-	t.toks = append(t.toks, &Token{kind, column, ru})
+	t.PushWide(kind, column, 1, ru)
+}
+
+func (t *Tokens) PushWide(kind TokenKind, column, width uint, ru rune) {
+	t.PushText(kind, column, width, ru, "")
+}
+
+func (t *Tokens) PushText(kind TokenKind, column, width uint, ru rune, text string) {
+	t.toks = append(t.toks, &Token{kind, column, width, ru, text})
+	if column+width > t.end {
+		t.end = column + width
+	}
+}
+
+// End returns the column right after the last pushed token.
+func (t *Tokens) End() uint {
+	if t.end == 0 {
+		return 1
+	}
+	return t.end
 }
 
 func (t *Tokens) Count() int {
@@ -99,9 +148,26 @@ func (t *Tokens) Get() *Token {
 	}
 	var ret *Token
 	ret, t.toks = t.toks[0], t.toks[1:]
+	t.prev = ret
 	return ret
 }
 
+// Prev returns the token which was last taken with Get.
+func (t *Tokens) Prev() *Token {
+	return t.prev
+}
+
+// First returns the first remaining token of the given kind, or nil if there
+// is none.
+func (t *Tokens) First(kind TokenKind) *Token {
+	for _, tok := range t.toks {
+		if tok.kind == kind {
+			return tok
+		}
+	}
+	return nil
+}
+
 func (t *Tokens) Cur() *Token {
 	if len(t.toks) == 0 {
 		return nil
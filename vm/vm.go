@@ -0,0 +1,591 @@
+// Package vm compiles a matcher tree into a flat Thompson NFA program and
+// executes it with a Pike VM. Unlike the backtracking matcher tree, the time
+// spent matching is bounded by the program length times the input length.
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/susji/mre/input"
+	"github.com/susji/mre/match"
+)
+
+const (
+	OP_RUNES = Op(iota)
+	OP_ANY
+	OP_SPLIT
+	OP_JMP
+	OP_SAVE
+	OP_BEGIN
+	OP_END
+	OP_MATCH
+	OP_TABLE
+	OP_BEGIN_LINE
+	OP_END_LINE
+)
+
+var OpNames = []string{
+	"runes",
+	"any",
+	"split",
+	"jmp",
+	"save",
+	"begin",
+	"end",
+	"match",
+	"table",
+	"beginline",
+	"endline",
+}
+
+type Op uint8
+
+// MAX_INST limits the number of instructions compiled for an expression.
+const MAX_INST = 1 << 16
+
+// ErrTooLarge tells that an expression needs more than MAX_INST instructions.
+var ErrTooLarge = errors.New("program too large")
+
+// Inst is a single program instruction. Execution continues from Out unless
+// the instruction says otherwise. OP_SPLIT continues from both Out and Arg,
+// preferring Out. OP_SAVE stores the current position into capture slot Arg.
+// OP_MATCH has the index of the matching expression in Arg when the program
+// is compiled out of a set of expressions.
+type Inst struct {
+	Op  Op
+	Out int
+	Arg int
+	// Ranges holds inclusive rune range pairs for OP_RUNES.
+	Ranges []rune
+	// Table holds the runes of OP_TABLE.
+	Table  *unicode.RangeTable
+	Negate bool
+}
+
+type Prog struct {
+	Inst []Inst
+	// NumCap is the number of capture groups. Each has two slots, one for
+	// the start and one for the end position.
+	NumCap int
+}
+
+type compiler struct {
+	prog *Prog
+	// expr is the index of the expression being compiled in a set, and its
+	// instructions begin from start.
+	expr, start int
+}
+
+// MatchRune tells if a rune-consuming instruction accepts r.
+func (i *Inst) MatchRune(r rune) bool {
+	switch i.Op {
+	case OP_ANY:
+		return true
+	case OP_RUNES:
+		for j := 0; j < len(i.Ranges); j += 2 {
+			if r >= i.Ranges[j] && r <= i.Ranges[j+1] {
+				return !i.Negate
+			}
+		}
+		return i.Negate
+	case OP_TABLE:
+		return unicode.Is(i.Table, r) != i.Negate
+	}
+	return false
+}
+
+func (i *Inst) String() string {
+	switch i.Op {
+	case OP_RUNES:
+		b := &strings.Builder{}
+		if i.Negate {
+			b.WriteString("^")
+		}
+		for j := 0; j < len(i.Ranges); j += 2 {
+			if i.Ranges[j] == i.Ranges[j+1] {
+				fmt.Fprintf(b, "'%c'", i.Ranges[j])
+			} else {
+				fmt.Fprintf(b, "'%c'-'%c'", i.Ranges[j], i.Ranges[j+1])
+			}
+		}
+		return fmt.Sprintf("%s %s -> %d", OpNames[i.Op], b.String(), i.Out)
+	case OP_SPLIT:
+		return fmt.Sprintf("%s -> %d, %d", OpNames[i.Op], i.Out, i.Arg)
+	case OP_SAVE:
+		return fmt.Sprintf("%s %d -> %d", OpNames[i.Op], i.Arg, i.Out)
+	case OP_MATCH:
+		if i.Arg > 0 {
+			return fmt.Sprintf("%s %d", OpNames[i.Op], i.Arg)
+		}
+		return OpNames[i.Op]
+	default:
+		return fmt.Sprintf("%s -> %d", OpNames[i.Op], i.Out)
+	}
+}
+
+// String builds and returns a textual listing of the program.
+func (p *Prog) String() string {
+	b := &strings.Builder{}
+	for pc := range p.Inst {
+		fmt.Fprintf(b, "%3d  %s\n", pc, p.Inst[pc].String())
+	}
+	return b.String()
+}
+
+func (c *compiler) pc() int {
+	return len(c.prog.Inst)
+}
+
+// emit appends an instruction which by default continues from the next one.
+func (c *compiler) emit(op Op) int {
+	pc := c.pc()
+	c.prog.Inst = append(c.prog.Inst, Inst{Op: op, Out: pc + 1})
+	return pc
+}
+
+func (c *compiler) runes(negate bool, ranges ...rune) {
+	pc := c.emit(OP_RUNES)
+	c.prog.Inst[pc].Ranges = ranges
+	c.prog.Inst[pc].Negate = negate
+}
+
+// skip makes split either go on to the instruction after it or skip to pc.
+// Going on is preferred unless lazy is set.
+func (c *compiler) skip(split, pc int, lazy bool) {
+	if lazy {
+		c.prog.Inst[split].Arg = split + 1
+		c.prog.Inst[split].Out = pc
+	} else {
+		c.prog.Inst[split].Arg = pc
+	}
+}
+
+// star emits a loop matching n zero or more times. Like with Go's regexp, if
+// n may match the empty string, the loop is an optional plus, so that an
+// empty iteration ends it.
+func (c *compiler) star(n match.Node, lazy bool) error {
+	split := c.emit(OP_SPLIT)
+	if match.Nullable(n) {
+		if err := c.plus(n, lazy); err != nil {
+			return err
+		}
+	} else {
+		if err := c.compile(n); err != nil {
+			return err
+		}
+		c.prog.Inst[c.emit(OP_JMP)].Out = split
+	}
+	c.skip(split, c.pc(), lazy)
+	return nil
+}
+
+// plus emits a loop matching n one or more times. After an iteration which
+// matched nothing, the thread going round again finds the loop visited at the
+// same position and dies, so only the one leaving the loop goes on.
+func (c *compiler) plus(n match.Node, lazy bool) error {
+	start := c.pc()
+	if err := c.compile(n); err != nil {
+		return err
+	}
+	split := c.emit(OP_SPLIT)
+	if lazy {
+		c.prog.Inst[split].Arg = start
+	} else {
+		c.prog.Inst[split].Out, c.prog.Inst[split].Arg = start, split+1
+	}
+	return nil
+}
+
+// quest emits code matching n zero or one times.
+func (c *compiler) quest(n match.Node, lazy bool) error {
+	split := c.emit(OP_SPLIT)
+	if err := c.compile(n); err != nil {
+		return err
+	}
+	c.skip(split, c.pc(), lazy)
+	return nil
+}
+
+// repeat emits n at least a and at most b times. Optional repetitions nest so
+// that a later one is only tried after an earlier one matched.
+func (c *compiler) repeat(n match.Node, a, b int, lazy bool) error {
+	if b == match.RANGE_UNBOUND {
+		// n{a,} is n{a-1}n+ like with Go's regexp.
+		for i := 0; i < a-1; i++ {
+			if err := c.compile(n); err != nil {
+				return err
+			}
+		}
+		if a == 0 {
+			return c.star(n, lazy)
+		}
+		return c.plus(n, lazy)
+	}
+	for i := 0; i < a; i++ {
+		if err := c.compile(n); err != nil {
+			return err
+		}
+	}
+	splits := []int{}
+	for i := a; i < b; i++ {
+		splits = append(splits, c.emit(OP_SPLIT))
+		if err := c.compile(n); err != nil {
+			return err
+		}
+	}
+	for _, split := range splits {
+		c.skip(split, c.pc(), lazy)
+	}
+	return nil
+}
+
+// setRanges collects the rune ranges of single-rune set members.
+func setRanges(members []match.Node) ([]rune, error) {
+	ranges := []rune{}
+	for _, m := range members {
+		switch v := m.(type) {
+		case *match.Rune:
+			ranges = append(ranges, v.Rune(), v.Rune())
+		case *match.RuneRange:
+			a, b := v.Range()
+			ranges = append(ranges, a, b)
+		default:
+			return nil, fmt.Errorf("unsupported set member %T", m)
+		}
+	}
+	return ranges, nil
+}
+
+func (c *compiler) compile(n match.Node) error {
+	// Counted repetitions are unrolled, so even a short expression may
+	// grow large.
+	if c.pc()-c.start > MAX_INST {
+		return ErrTooLarge
+	}
+	switch v := n.(type) {
+	case *match.Rune:
+		c.runes(false, v.Rune(), v.Rune())
+	case *match.RuneRange:
+		a, b := v.Range()
+		c.runes(false, a, b)
+	case *match.NotRune:
+		c.runes(true, v.Rune(), v.Rune())
+	case *match.String:
+		for _, r := range v.Runes() {
+			c.runes(false, r, r)
+		}
+	case *match.RangeTable:
+		c.runes(v.Negated(), v.Ranges()...)
+	case *match.Class:
+		c.runes(false, v.Ranges()...)
+	case *match.Property:
+		pc := c.emit(OP_TABLE)
+		c.prog.Inst[pc].Table = v.Table()
+		c.prog.Inst[pc].Negate = v.Negated()
+	case *match.NoneOf:
+		ranges, err := setRanges(v.Subs())
+		if err != nil {
+			return err
+		}
+		c.runes(true, ranges...)
+	case *match.Any:
+		c.emit(OP_ANY)
+	case *match.All:
+		for _, nn := range v.Subs() {
+			if err := c.compile(nn); err != nil {
+				return err
+			}
+		}
+	case *match.AnyOf:
+		subs := v.Subs()
+		jmps := []int{}
+		for i, nn := range subs {
+			var split int
+			if i < len(subs)-1 {
+				split = c.emit(OP_SPLIT)
+			}
+			if err := c.compile(nn); err != nil {
+				return err
+			}
+			if i < len(subs)-1 {
+				jmps = append(jmps, c.emit(OP_JMP))
+				c.prog.Inst[split].Arg = c.pc()
+			}
+		}
+		for _, jmp := range jmps {
+			c.prog.Inst[jmp].Out = c.pc()
+		}
+	case *match.ZeroOrOne:
+		return c.quest(v.Sub(), false)
+	case *match.ZeroOrMore:
+		return c.star(v.Sub(), false)
+	case *match.OneOrMore:
+		return c.repeat(v.Sub(), 1, match.RANGE_UNBOUND, false)
+	case *match.N:
+		return c.repeat(v.Sub(), v.Count(), v.Count(), false)
+	case *match.LengthRange:
+		a, b := v.Bounds()
+		return c.repeat(v.Sub(), a, b, false)
+	case *match.Lazy:
+		a, b := v.Bounds()
+		if a == 0 && b == 1 {
+			return c.quest(v.Sub(), true)
+		}
+		return c.repeat(v.Sub(), a, b, true)
+	case *match.Atomic:
+		return fmt.Errorf("atomic matching needs backtracking")
+	case *match.Assert:
+		c.emit([]Op{OP_BEGIN, OP_END, OP_BEGIN_LINE, OP_END_LINE}[v.Kind()])
+	case *match.Capture:
+		if v.ID() >= c.prog.NumCap {
+			c.prog.NumCap = v.ID() + 1
+		}
+		c.prog.Inst[c.emit(OP_SAVE)].Arg = v.ID() * 2
+		if err := c.compile(v.Sub()); err != nil {
+			return err
+		}
+		c.prog.Inst[c.emit(OP_SAVE)].Arg = v.ID()*2 + 1
+	case *match.Exhaustive:
+		if err := c.compile(v.Sub()); err != nil {
+			return err
+		}
+		c.emit(OP_END)
+	case *match.ScanTry:
+		// Scanning is the same as a leading `.*?', that is, we prefer
+		// trying the subexpression over skipping a rune.
+		split := c.emit(OP_SPLIT)
+		c.prog.Inst[c.emit(OP_ANY)].Out = split
+		c.prog.Inst[split].Arg = split + 1
+		c.prog.Inst[split].Out = c.pc()
+		return c.compile(v.Sub())
+	case *match.Root:
+		// When searching from somewhere else than the beginning, an
+		// anchored expression must not match.
+		if v.Anchored() {
+			c.emit(OP_BEGIN)
+		}
+		if err := c.compile(v.Sub()); err != nil {
+			return err
+		}
+		c.prog.Inst[c.emit(OP_MATCH)].Arg = c.expr
+	default:
+		return fmt.Errorf("unsupported matcher %T", n)
+	}
+	return nil
+}
+
+// Compile builds a program out of a matcher tree.
+func Compile(root *match.Root) (*Prog, error) {
+	c := &compiler{prog: &Prog{}}
+	if err := c.compileRoot(root); err != nil {
+		return nil, fmt.Errorf("vm: %w", err)
+	}
+	return c.prog, nil
+}
+
+// compileRoot compiles the expression root and checks that it did not grow
+// too large.
+func (c *compiler) compileRoot(root *match.Root) error {
+	if err := c.compile(root); err != nil {
+		return err
+	}
+	if c.pc()-c.start > MAX_INST {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// CompileSet builds a single program out of several matcher trees. The
+// program runs them all side by side, and each of them reaches its own
+// OP_MATCH with its index in roots.
+func CompileSet(roots []*match.Root) (*Prog, error) {
+	c := &compiler{prog: &Prog{}}
+	splits := []int{}
+	for i, root := range roots {
+		if i > 0 {
+			c.prog.Inst[splits[i-1]].Arg = c.pc()
+		}
+		if i < len(roots)-1 {
+			splits = append(splits, c.emit(OP_SPLIT))
+		}
+		c.expr, c.start = i, c.pc()
+		if err := c.compileRoot(root); err != nil {
+			return nil, fmt.Errorf("vm: expression %d: %w", i, err)
+		}
+	}
+	return c.prog, nil
+}
+
+type thread struct {
+	pc   int
+	caps []int
+}
+
+// queue is a sparse set of threads ordered by priority.
+type queue struct {
+	sparse []int
+	dense  []thread
+}
+
+func newQueue(n int) *queue {
+	return &queue{sparse: make([]int, n), dense: make([]thread, 0, n)}
+}
+
+func (q *queue) has(pc int) bool {
+	i := q.sparse[pc]
+	return i < len(q.dense) && q.dense[i].pc == pc
+}
+
+func (q *queue) clear() {
+	q.dense = q.dense[:0]
+}
+
+type machine struct {
+	prog  *Prog
+	input input.Input
+}
+
+// newlineAt tells if there is a '\n' at byte offset pos.
+func (m *machine) newlineAt(pos int) bool {
+	r, _ := m.input.Step(pos)
+	return r == '\n'
+}
+
+// add follows the empty transitions from pc in order of priority and queues
+// the threads which wait for a rune or a match.
+func (m *machine) add(q *queue, pc, pos int, caps []int) {
+	if q.has(pc) {
+		return
+	}
+	q.sparse[pc] = len(q.dense)
+	q.dense = append(q.dense, thread{pc: pc})
+	inst := &m.prog.Inst[pc]
+	switch inst.Op {
+	case OP_JMP:
+		m.add(q, inst.Out, pos, caps)
+	case OP_SPLIT:
+		m.add(q, inst.Out, pos, caps)
+		m.add(q, inst.Arg, pos, caps)
+	case OP_SAVE:
+		ncaps := make([]int, len(caps))
+		copy(ncaps, caps)
+		ncaps[inst.Arg] = pos
+		m.add(q, inst.Out, pos, ncaps)
+	case OP_BEGIN:
+		if pos == 0 {
+			m.add(q, inst.Out, pos, caps)
+		}
+	case OP_END:
+		if pos == m.input.Len() {
+			m.add(q, inst.Out, pos, caps)
+		}
+	case OP_BEGIN_LINE:
+		if pos == 0 || m.newlineAt(pos-1) {
+			m.add(q, inst.Out, pos, caps)
+		}
+	case OP_END_LINE:
+		if pos == m.input.Len() || m.newlineAt(pos) {
+			m.add(q, inst.Out, pos, caps)
+		}
+	default:
+		q.dense[len(q.dense)-1].caps = caps
+	}
+}
+
+// Match runs the program on in. If it matches, the capture slots of the
+// preferred match are returned as byte offsets. Slots of groups which did not
+// participate in the match are -1. If there is no match, nil is returned.
+func (p *Prog) Match(in input.Input) []int {
+	return p.MatchAt(in, 0)
+}
+
+// MatchAt is like Match, but the program starts running from byte offset at.
+func (p *Prog) MatchAt(in input.Input, at int) []int {
+	m := &machine{prog: p, input: in}
+	clist, nlist := newQueue(len(p.Inst)), newQueue(len(p.Inst))
+	caps := make([]int, p.NumCap*2)
+	for i := range caps {
+		caps[i] = -1
+	}
+	var matched []int
+	m.add(clist, 0, at, caps)
+	for pos := at; len(clist.dense) > 0; {
+		r, w := in.Step(pos)
+		ok := w > 0
+	threads:
+		for _, t := range clist.dense {
+			inst := &p.Inst[t.pc]
+			switch inst.Op {
+			case OP_MATCH:
+				// Threads after this one have a lower priority, so they
+				// are cut off.
+				matched = t.caps
+				break threads
+			case OP_RUNES, OP_ANY, OP_TABLE:
+				if ok && inst.MatchRune(r) {
+					m.add(nlist, inst.Out, pos+w, t.caps)
+				}
+			}
+		}
+		if !ok {
+			break
+		}
+		clist, nlist = nlist, clist
+		nlist.clear()
+		pos += w
+	}
+	return matched
+}
+
+// MatchSet runs a program built by CompileSet on in and returns the sorted
+// indices of the expressions which match, or nil if none does.
+func (p *Prog) MatchSet(in input.Input) []int {
+	m := &machine{prog: p, input: in}
+	clist, nlist := newQueue(len(p.Inst)), newQueue(len(p.Inst))
+	caps := make([]int, p.NumCap*2)
+	matched := map[int]bool{}
+	m.add(clist, 0, 0, caps)
+	for pos := 0; len(clist.dense) > 0; {
+		r, w := in.Step(pos)
+		ok := w > 0
+		for _, t := range clist.dense {
+			inst := &p.Inst[t.pc]
+			switch inst.Op {
+			case OP_MATCH:
+				// Unlike when looking for the preferred match, every
+				// thread is kept, as they may belong to other
+				// expressions.
+				matched[inst.Arg] = true
+			case OP_RUNES, OP_ANY, OP_TABLE:
+				if ok && inst.MatchRune(r) {
+					m.add(nlist, inst.Out, pos+w, t.caps)
+				}
+			}
+		}
+		if !ok {
+			break
+		}
+		clist, nlist = nlist, clist
+		nlist.clear()
+		pos += w
+	}
+	return SortedSet(matched)
+}
+
+// SortedSet returns the members of set in ascending order, or nil if set is
+// empty.
+func SortedSet(set map[int]bool) []int {
+	if len(set) == 0 {
+		return nil
+	}
+	ret := make([]int, 0, len(set))
+	for i := range set {
+		ret = append(ret, i)
+	}
+	sort.Ints(ret)
+	return ret
+}
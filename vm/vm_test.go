@@ -0,0 +1,100 @@
+package vm_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/susji/mre/compile"
+	"github.com/susji/mre/input"
+	"github.com/susji/mre/lex"
+	"github.com/susji/mre/match"
+	"github.com/susji/mre/vm"
+)
+
+func TestMatch(t *testing.T) {
+	type entry struct {
+		expr, test string
+		exp        []int
+	}
+
+	table := []entry{
+		{"^a", "ab", []int{0, 1}},
+		{"^a", "ba", nil},
+		{"a", "ba", []int{1, 2}},
+		{"b+", "abbbc", []int{1, 4}},
+		{"^a*ab$", "aaab", []int{0, 4}},
+		{"^(a|ab)c$", "abc", []int{0, 3, 0, 2}},
+		{"(a|ab)(c|bcd)", "abcd", []int{0, 4, 0, 1, 1, 4}},
+		{"^[^a-c]+", "xyzab", []int{0, 3}},
+		{"^a{2,3}", "aaaa", []int{0, 3}},
+		{"^a{2,}", "aaaa", []int{0, 4}},
+		{"^a{2}", "aaaa", []int{0, 2}},
+		{"^a{2}", "a", nil},
+		{"x*", "", []int{0, 0}},
+		{"c$", "abcc", []int{3, 4}},
+		{"^(a)?b", "b", []int{0, 1, -1, -1}},
+		{"(ö+)ä", "xööä", []int{1, 7, 1, 5}},
+	}
+
+	for _, te := range table {
+		t.Run(te.expr+"_"+te.test, func(t *testing.T) {
+			root, err := compile.Compile(lex.Lex(te.expr))
+			if err != nil {
+				t.Fatal("compile failed: ", err)
+			}
+			prog, err := vm.Compile(root)
+			if err != nil {
+				t.Fatal("vm compile failed: ", err)
+			}
+			got := prog.Match(input.String(te.test))
+			// Only the leading slots given in the table are compared.
+			if te.exp == nil {
+				if got != nil {
+					t.Errorf("wanted no match, got %v", got)
+				}
+				return
+			}
+			if len(got) < len(te.exp) ||
+				!reflect.DeepEqual(got[:len(te.exp)], te.exp) {
+				t.Log(prog)
+				t.Errorf("wanted %v, got %v", te.exp, got)
+			}
+		})
+	}
+}
+
+func TestPathological(t *testing.T) {
+	// With backtracking, this would take exponential time.
+	root := match.NewRoot(
+		match.NewExhaustive(
+			match.NewCapture(
+				match.NewAll(
+					match.NewZeroOrMore(
+						match.NewZeroOrMore(match.NewRune('a'))),
+					match.NewRune('b')), 0))).(*match.Root)
+	prog, err := vm.Compile(root)
+	if err != nil {
+		t.Fatal("vm compile failed: ", err)
+	}
+	if prog.Match(input.String(strings.Repeat("a", 1000))) != nil {
+		t.Error("should not match")
+	}
+	if prog.Match(input.String(strings.Repeat("a", 1000)+"b")) == nil {
+		t.Error("should match")
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	root, err := compile.Compile(lex.Lex("(a{1000}){1000}"))
+	if err != nil {
+		t.Fatal("compile failed: ", err)
+	}
+	if _, err := vm.Compile(root); !errors.Is(err, vm.ErrTooLarge) {
+		t.Errorf("wanted %v, got %v", vm.ErrTooLarge, err)
+	}
+	if _, err := vm.CompileSet([]*match.Root{root}); !errors.Is(err, vm.ErrTooLarge) {
+		t.Errorf("wanted %v, got %v", vm.ErrTooLarge, err)
+	}
+}